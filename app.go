@@ -32,6 +32,11 @@ type Security struct {
 	XFrameOptions XFrameOptions
 	NoSniff       bool
 	CSP           string
+	// CSPNonce adds a random nonce to the script-src and style-src of the CSP
+	// of each request. Templates get it as the Nonce of Page, and handlers
+	// with GetNonce. As the nonce differs between requests, the responses
+	// are neither cached publicly, nor by the PageCache, nor given an ETag.
+	CSPNonce bool
 	// TODO: HSTS
 }
 
@@ -42,16 +47,192 @@ var DefaultSecurity = Security{
 }
 
 type App struct {
-	BaseURL         string
-	Location        *time.Location
+	BaseURL  string
+	Location *time.Location
+
+	// StaticResources is the file system the static files are served from,
+	// rooted at the static directory. Use fs.Sub to serve an embed.FS. If it
+	// is nil the StaticPrefix directory is used.
 	StaticResources fs.FS
-	URLs            URLs
-	Handler404      http.Handler
-	CSRF            *csrf.CSRF
-	Security        *Security
+
+	// TemplateResources is the file system the templates are read from,
+	// rooted at the templates directory. If it is nil the TemplatesPrefix
+	// directory is used.
+	TemplateResources fs.FS
+
+	// Templates are the pages of the app. They are built when the app starts,
+	// which fails outside of dev mode if one of them is broken, and checked
+	// by Validate. Pages not listed are built when they are first rendered.
+	Templates []*Template
+
+	// FlashPartial is the template the flashes are rendered with when an
+	// htmx request is answered with only a block of the page. It is executed
+	// with the []flash.Data and its output appended to the block, so it should
+	// mark its root element with hx-swap-oob. If it is empty, the flashes are
+	// sent as the HTMXFlashEvent instead.
+	FlashPartial string
+
+	// StaticManifestFile is the path of a JSON manifest written by
+	// cmd/esox-manifest. If it is empty, the manifest is built by hashing all
+	// of the static files on startup. It is not used in dev mode.
+	StaticManifestFile string
+
+	// StaticMount is the URL path the static files are served under. It
+	// defaults to DefaultStaticMount.
+	StaticMount string
+
+	// StaticRootFiles are the static files, and directories when ending with
+	// a slash, which are also served from the root of the site. It defaults
+	// to DefaultStaticRootFiles. Files which do not exist are skipped.
+	StaticRootFiles []string
+
+	// MIMETypes override the content types of the static files by extension,
+	// for example ".glb": "model/gltf-binary".
+	MIMETypes map[string]string
+
+	// AssetHost is the scheme and host, for example https://cdn.example.com,
+	// the static files are referred to from instead of the app itself. The
+	// files are expected under the same StaticMount path, see
+	// StaticManifest.Export. The CSP in Security must allow the host.
+	AssetHost string
+
+	// Preload announces the static files used by rendered templates with Link
+	// headers, and optionally with 103 Early Hints over HTTP/2 and newer.
+	Preload Preload
+
+	// Bundles are static files concatenated and minified from other static
+	// files. They must match the bundles given to cmd/esox-manifest.
+	Bundles []Bundle
+
+	// SRIAlgorithms are the hash algorithms listed in the integrity attributes
+	// of the static files. It defaults to DefaultSRIAlgorithms and must match
+	// the algorithms given to cmd/esox-manifest.
+	SRIAlgorithms []SRIAlgorithm
+
+	// ImageWidths are the widths the image template func may resize JPEG and
+	// PNG images to. It defaults to DefaultImageWidths.
+	ImageWidths []int
+	// ImageCacheDir is the directory resized images are cached in between
+	// restarts, they are only kept in memory when empty.
+	ImageCacheDir string
+
+	// MaxJSONBytes is the maximum size of a request body DecodeJSON reads. It
+	// defaults to DefaultMaxJSONBytes.
+	MaxJSONBytes int64
+
+	// PageCache caches the public pages of the URLs in memory. It is not
+	// used in dev mode. Pages can be invalidated by the name of their URL.
+	PageCache *PageCache
+
+	URLs       URLs
+	Handler404 http.Handler
+	CSRF       *csrf.CSRF
+	Security   *Security
+}
+
+func (a *App) staticFS() fs.FS {
+	if a.StaticResources != nil {
+		return a.StaticResources
+	}
+
+	return os.DirFS(StaticPrefix)
+}
+
+func (a *App) staticMount() string {
+	mount := a.StaticMount
+	if mount == "" {
+		return DefaultStaticMount
+	}
+
+	if !strings.HasPrefix(mount, "/") {
+		mount = "/" + mount
+	}
+
+	if !strings.HasSuffix(mount, "/") {
+		mount += "/"
+	}
+
+	return mount
 }
 
-func (a *App) middleware(log zerolog.Logger) (alice.Chain, error) {
+// staticRootFiles returns the static root files which exist, mapped from the
+// URL path they are served at.
+func (a *App) staticRootFiles(manifest *StaticManifest) (map[string]string, error) {
+	rootFiles := a.StaticRootFiles
+	if rootFiles == nil {
+		rootFiles = DefaultStaticRootFiles
+	}
+
+	paths, err := manifest.Paths()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string)
+	for _, rootFile := range rootFiles {
+		rootFile = strings.TrimPrefix(rootFile, "/")
+		for _, p := range paths {
+			if p == rootFile || (strings.HasSuffix(rootFile, "/") && strings.HasPrefix(p, rootFile)) {
+				out["/"+rootFile] = rootFile
+				break
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (a *App) templateFS() fs.FS {
+	if a.TemplateResources != nil {
+		return a.TemplateResources
+	}
+
+	return os.DirFS(TemplatesPrefix)
+}
+
+// templates loads the templates. Outside of dev mode a template which fails
+// to parse prevents the app from starting, in dev mode the error is logged
+// and shown again when the page is rendered.
+func (a *App) templates(log zerolog.Logger, dev bool) (*Templates, error) {
+	templates, err := LoadTemplates(a.templateFS(), dev, a.Templates...)
+	if err != nil && dev {
+		log.Err(err).Msg("Failed to load templates.")
+		return templates, nil
+	}
+
+	return templates, err
+}
+
+func (a *App) staticManifest(log zerolog.Logger, dev bool) (*StaticManifest, error) {
+	fsys := a.staticFS()
+	opts := StaticOptions{
+		Bundles:       a.Bundles,
+		SRIAlgorithms: a.SRIAlgorithms,
+		ImageWidths:   a.ImageWidths,
+		ImageCacheDir: a.ImageCacheDir,
+		MIMETypes:     a.MIMETypes,
+	}
+
+	if dev {
+		return opts.NewDevManifest(fsys)
+	}
+
+	if a.StaticManifestFile != "" {
+		file, err := os.Open(a.StaticManifestFile)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		log.Info().Str("file", a.StaticManifestFile).Msg("Loading static manifest.")
+		return opts.ReadManifest(fsys, file)
+	}
+
+	log.Info().Msg("Building static manifest.")
+	return opts.BuildManifest(fsys)
+}
+
+func (a *App) middleware(log zerolog.Logger, dev bool) (alice.Chain, error) {
 	c := alice.New()
 
 	c = c.Append(hlog.NewHandler(log))
@@ -87,7 +268,20 @@ func (a *App) middleware(log zerolog.Logger) (alice.Chain, error) {
 			}
 
 			if security.CSP != "" {
-				w.Header().Set("Content-Security-Policy", security.CSP)
+				csp := security.CSP
+				if dev {
+					csp = liveReloadCSP(csp)
+				}
+
+				if security.CSPNonce {
+					nonce := newNonce()
+					source := "'nonce-" + nonce + "'"
+					csp = addCSPSource(csp, "script-src", source)
+					csp = addCSPSource(csp, "style-src", source)
+					r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, nonce))
+				}
+
+				w.Header().Set("Content-Security-Policy", csp)
 			}
 
 			next.ServeHTTP(w, r)
@@ -145,32 +339,81 @@ func (a *App) middleware(log zerolog.Logger) (alice.Chain, error) {
 func (a *App) Handler(ctx context.Context) (http.Handler, error) {
 	log := zerolog.Ctx(ctx)
 
+	runConfig, _ := ctx.Value(runConfigKey{}).(RunConfig)
+
 	mux := http.NewServeMux()
-	c, err := a.middleware(*log)
+	c, err := a.middleware(*log, runConfig.Dev)
 	if err != nil {
 		return nil, err
 	}
 
-	mux.Handle("/static/", c.ThenFunc(staticHandler))
+	if runConfig.Dev {
+		lr := newLiveReload(a.templateFS(), a.staticFS(), GetStaticManifest(ctx), getStaticConfig(ctx))
+		go lr.watch(ctx, *log)
+		mux.Handle(LiveReloadPath, c.ThenFunc(lr.handler(ctx)))
+	}
+
+	mount := a.staticMount()
+	mux.Handle(mount, c.ThenFunc(staticHandler(mount, "")))
+
+	rootFiles, err := a.staticRootFiles(GetStaticManifest(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, url := range a.URLs {
+		if _, ok := rootFiles[url.Path]; ok {
+			log.Warn().
+				Str("name", url.Name).
+				Str("path", url.Path).
+				Msg("URL path is also a static root file, the static file is not served")
+			delete(rootFiles, url.Path)
+		}
+	}
+
+	for urlPath, rootFile := range rootFiles {
+		// A root file may itself be hidden, like /.well-known/, but the
+		// dotfiles inside of it are not served.
+		mux.Handle(urlPath, c.ThenFunc(staticHandler("/", rootFile)))
+	}
 
 	hasRootPath := false
 	for _, url := range a.URLs {
-		if strings.HasPrefix(url.Path, "/static/") {
+		if strings.HasPrefix(url.Path, mount) {
 			log.Fatal().
 				Str("name", url.Name).
 				Str("path", url.Path).
-				Msg("URL path cannot start with /static/")
+				Msgf("URL path cannot start with %s", mount)
+		}
+
+		uc := c
+		if a.PageCache != nil && !runConfig.Dev {
+			uc = c.Append(a.PageCache.Middleware(url.Name))
+		}
+
+		var jsonURLPath string
+		if url.JSON {
+			jsonURLPath, err = jsonPath(url.Path)
+			if err != nil {
+				return nil, fmt.Errorf("URL %s: %w", url.Name, err)
+			}
+
+			uc = uc.Append(jsonHandler(jsonAccept))
 		}
 
 		if url.Path == "/" && a.Handler404 != nil {
 			hasRootPath = true
 			mux.Handle(
 				url.Path,
-				c.Append(notFoundMiddleware(a.Handler404)).
+				uc.Append(notFoundMiddleware(a.Handler404)).
 					Then(url.Handler),
 			)
 		} else {
-			mux.Handle(url.Path, c.Then(url.Handler))
+			mux.Handle(url.Path, uc.Then(url.Handler))
+		}
+
+		if url.JSON {
+			mux.Handle(jsonURLPath, uc.Append(jsonHandler(jsonAlways)).Then(url.Handler))
 		}
 	}
 
@@ -192,7 +435,24 @@ type RunConfig struct {
 	ShutdownTimeout time.Duration
 }
 
-func (a *App) setupCtx(ctx context.Context, log zerolog.Logger, conf RunConfig) context.Context {
+func (a *App) nameMapping(log zerolog.Logger) map[string]URL {
+	nameMapping := make(map[string]URL, len(a.URLs))
+	for _, url := range a.URLs {
+		oldURL, ok := nameMapping[url.Name]
+		if ok {
+			log.Warn().
+				Str("oldPath", oldURL.Path).
+				Str("newPath", url.Path).
+				Msg("URL name collision")
+		}
+
+		nameMapping[url.Name] = url
+	}
+
+	return nameMapping
+}
+
+func (a *App) setupCtx(ctx context.Context, log zerolog.Logger, conf RunConfig) (context.Context, error) {
 	ctx = log.WithContext(ctx)
 
 	if a.CSRF != nil {
@@ -208,28 +468,66 @@ func (a *App) setupCtx(ctx context.Context, log zerolog.Logger, conf RunConfig)
 		ctx = context.WithValue(ctx, locationKey{}, time.UTC)
 	}
 
-	nameMapping := make(map[string]URL, len(a.URLs))
-	for _, url := range a.URLs {
-		oldURL, ok := nameMapping[url.Name]
-		if ok {
-			log.Warn().
-				Str("oldPath", oldURL.Path).
-				Str("newPath", url.Path).
-				Msg("URL name collision")
-		}
+	nameMapping := a.nameMapping(log)
+	ctx = context.WithValue(ctx, nameMappingKey{}, nameMapping)
 
-		nameMapping[url.Name] = url
+	manifest, err := a.staticManifest(log, conf.Dev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up static manifest: %w", err)
 	}
-	ctx = context.WithValue(ctx, nameMappingKey{}, nameMapping)
+	ctx = context.WithValue(ctx, staticManifestKey{}, manifest)
+	ctx = context.WithValue(ctx, staticConfigKey{}, staticConfig{
+		host:      strings.TrimSuffix(a.AssetHost, "/"),
+		mount:     a.staticMount(),
+		mimeTypes: a.MIMETypes,
+		dev:       conf.Dev,
+	})
+	ctx = context.WithValue(ctx, preloadKey{}, a.Preload)
 
-	return context.WithValue(ctx, runConfigKey{}, conf)
+	templates, err := a.templates(log, conf.Dev)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load templates: %w", err)
+	}
+	ctx = context.WithValue(ctx, templatesKey{}, templates)
+	ctx = context.WithValue(ctx, liveReloadKey{}, conf.Dev)
+	ctx = context.WithValue(ctx, flashPartialKey{}, a.FlashPartial)
+	ctx = context.WithValue(ctx, maxJSONBytesKey{}, a.MaxJSONBytes)
+
+	return context.WithValue(ctx, runConfigKey{}, conf), nil
+}
+
+// Context returns the context the handlers of the app get, with the location,
+// the URL mapping, CSRF, the static files and the templates set up, without
+// running a server. Use it to render templates with Template.Execute outside
+// of requests, for example for emails or in tests. With conf.Dev set the
+// templates and static files are reloaded when they change, like with Run.
+//
+// Each call builds the static manifest and parses the templates again, so
+// the context should be set up once, for example on startup, and reused.
+func (a *App) Context(ctx context.Context, conf RunConfig) (context.Context, error) {
+	return a.setupCtx(ctx, *zerolog.Ctx(ctx), conf)
 }
 
 func (a *App) Run(ctx context.Context, conf RunConfig) error {
 	log := setupLogger(conf.Dev)
-	ctx = a.setupCtx(ctx, log, conf)
+	ctx, err := a.setupCtx(ctx, log, conf)
+	if err != nil {
+		return err
+	}
+
+	if !conf.Dev {
+		err := a.Validate(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to validate app: %w", err)
+		}
+	}
+
+	// The handler context is canceled before shutting down, so that long
+	// lived requests like the live reload event streams finish.
+	handlerCtx, stopHandler := context.WithCancel(ctx)
+	defer stopHandler()
 
-	handler, err := a.Handler(ctx)
+	handler, err := a.Handler(handlerCtx)
 	if err != nil {
 		return err
 	}
@@ -273,6 +571,8 @@ func (a *App) Run(ctx context.Context, conf RunConfig) error {
 		t = DefaultShutdownTimeout
 	}
 
+	stopHandler()
+
 	ctx, cancel := context.WithTimeout(ctx, t)
 	defer cancel()
 
@@ -0,0 +1,137 @@
+package esox
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// CachePolicy describes how a response may be cached, it is written as the
+// Cache-Control and Vary headers.
+type CachePolicy struct {
+	// NoStore forbids caching the response at all, the rest of the policy is
+	// ignored apart from Vary.
+	NoStore bool
+	// NoCache requires caches to revalidate the response before using it.
+	NoCache bool
+	// Public allows shared caches, like CDNs, to store the response.
+	// Otherwise only the browser may store it.
+	Public bool
+
+	MaxAge time.Duration
+	// SMaxAge is the max-age for shared caches, it implies Public.
+	SMaxAge              time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	MustRevalidate       bool
+	Immutable            bool
+
+	// Vary are the request headers the response depends on.
+	Vary []string
+
+	// isPrivate makes the policy private even when it has no other
+	// directive, see private.
+	isPrivate bool
+}
+
+// CachePolicyData can be implemented by RenderData, or by the values given to
+// RenderJSON, to have the CachePolicy applied instead of CacheControl. Unlike
+// CacheControl, the policy is applied to responses with any status code.
+type CachePolicyData interface {
+	CachePolicy() CachePolicy
+}
+
+func (p CachePolicy) String() string {
+	if p.NoStore {
+		return "no-store"
+	}
+
+	var directives []string
+	if p.Public || p.SMaxAge > 0 {
+		directives = append(directives, "public")
+	} else if p.isPrivate || p.MaxAge > 0 || p.MustRevalidate || p.Immutable || p.StaleWhileRevalidate > 0 || p.StaleIfError > 0 {
+		directives = append(directives, "private")
+	}
+
+	if p.NoCache {
+		directives = append(directives, "no-cache")
+	}
+
+	seconds := func(name string, d time.Duration) {
+		if d > 0 {
+			directives = append(directives, fmt.Sprintf("%s=%d", name, int(d.Seconds())))
+		}
+	}
+
+	seconds("max-age", p.MaxAge)
+	seconds("s-maxage", p.SMaxAge)
+	seconds("stale-while-revalidate", p.StaleWhileRevalidate)
+	seconds("stale-if-error", p.StaleIfError)
+
+	if p.MustRevalidate {
+		directives = append(directives, "must-revalidate")
+	}
+
+	if p.Immutable {
+		directives = append(directives, "immutable")
+	}
+
+	return strings.Join(directives, ", ")
+}
+
+// private returns the policy with shared caches disallowed. The policy is
+// written as private even when it would otherwise be empty, as shared caches
+// may store a response without a Cache-Control heuristically.
+func (p CachePolicy) private() CachePolicy {
+	p.Public = false
+	p.SMaxAge = 0
+	p.isPrivate = true
+	return p
+}
+
+// isAuthenticated reports whether the request may carry credentials, in which
+// case the response may be personal and must not be cached publicly.
+func isAuthenticated(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// responseCachePolicy returns the cache policy of the data. RenderData which
+// does not implement CachePolicyData has its CacheControl converted to a
+// policy, which is only applied to 200 responses as before.
+func responseCachePolicy(data any) (policy CachePolicy, explicit bool) {
+	switch data := data.(type) {
+	case CachePolicyData:
+		return data.CachePolicy(), true
+	case interface {
+		CacheControl() (public bool, maxAge time.Duration)
+	}:
+		public, maxAge := data.CacheControl()
+		return CachePolicy{Public: public, MaxAge: maxAge}, false
+	}
+
+	return CachePolicy{}, false
+}
+
+// applyCachePolicy writes the cache policy of the data to the response
+// headers. Responses to authenticated requests, responses setting cookies
+// like the deletion of the flash cookie, and responses with a CSP nonce or a
+// CSRF token are never cached publicly.
+func applyCachePolicy(w http.ResponseWriter, r *http.Request, code int, data any) {
+	policy, explicit := responseCachePolicy(data)
+	if !explicit && code != http.StatusOK {
+		policy = CachePolicy{}
+	}
+
+	if isAuthenticated(r) || len(w.Header().Values("Set-Cookie")) > 0 || isRequestSpecific(r.Context()) {
+		policy = policy.private()
+	}
+
+	for _, header := range policy.Vary {
+		w.Header().Add("Vary", header)
+	}
+
+	if cacheControl := policy.String(); cacheControl != "" {
+		w.Header().Set("Cache-Control", cacheControl)
+	}
+}
@@ -0,0 +1,68 @@
+package esox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCachePolicyString(t *testing.T) {
+	for expected, policy := range map[string]CachePolicy{
+		"":                    {},
+		"no-store":            {NoStore: true, Public: true, MaxAge: time.Hour},
+		"no-cache":            {NoCache: true},
+		"private, max-age=60": {MaxAge: time.Minute},
+		"public, no-cache":    {Public: true, NoCache: true},
+		"public, max-age=60, s-maxage=3600, stale-while-revalidate=30, stale-if-error=86400": {
+			MaxAge: time.Minute, SMaxAge: time.Hour, StaleWhileRevalidate: 30 * time.Second, StaleIfError: 24 * time.Hour,
+		},
+		"public, max-age=31536000, immutable": {Public: true, MaxAge: 365 * 24 * time.Hour, Immutable: true},
+		"private, must-revalidate":            {MustRevalidate: true},
+	} {
+		assert.Equal(t, expected, policy.String())
+	}
+}
+
+type testCachePolicyData struct {
+	policy CachePolicy
+}
+
+func (d testCachePolicyData) CachePolicy() CachePolicy { return d.policy }
+
+func TestApplyCachePolicy(t *testing.T) {
+	apply := func(r *http.Request, code int, data any, setCookie bool) http.Header {
+		w := httptest.NewRecorder()
+		if setCookie {
+			http.SetCookie(w, &http.Cookie{Name: "flash", MaxAge: -1})
+		}
+
+		applyCachePolicy(w, r, code, data)
+		return w.Header()
+	}
+
+	anonymous := httptest.NewRequest(http.MethodGet, "/", nil)
+	authenticated := httptest.NewRequest(http.MethodGet, "/", nil)
+	authenticated.Header.Set("Cookie", "session=x")
+
+	public := testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute, SMaxAge: time.Hour, Vary: []string{"Accept-Language"}}}
+
+	header := apply(anonymous, http.StatusNotFound, public, false)
+	assert.Equal(t, "public, max-age=60, s-maxage=3600", header.Get("Cache-Control"))
+	assert.Equal(t, "Accept-Language", header.Get("Vary"))
+
+	assert.Equal(t, "private, max-age=60", apply(authenticated, http.StatusOK, public, false).Get("Cache-Control"))
+	assert.Equal(t, "private, max-age=60", apply(anonymous, http.StatusOK, public, true).Get("Cache-Control"))
+
+	// A response without a policy is not left for shared caches to store.
+	assert.Equal(t, "private", apply(authenticated, http.StatusOK, testCachePolicyData{}, false).Get("Cache-Control"))
+	assert.Equal(t, "private", apply(anonymous, http.StatusOK, testCachePolicyData{CachePolicy{Public: true}}, true).Get("Cache-Control"))
+	assert.Equal(t, "private, no-cache", apply(authenticated, http.StatusOK, testCachePolicyData{CachePolicy{NoCache: true}}, false).Get("Cache-Control"))
+	assert.Equal(t, "private", apply(authenticated, http.StatusNotFound, &testJSONData{}, false).Get("Cache-Control"))
+
+	legacy := testJSONData{}
+	assert.Equal(t, "public, max-age=60", apply(anonymous, http.StatusOK, &legacy, false).Get("Cache-Control"))
+	assert.Empty(t, apply(anonymous, http.StatusNotFound, &legacy, false).Get("Cache-Control"))
+}
@@ -0,0 +1,156 @@
+// Command esox-compress writes precompressed .br and .gz variants next to the
+// files in a static directory, so that they can be served without compressing
+// them on every request. The variants contain the content as it is served, so
+// for stylesheets they have to be regenerated when a referenced file changes.
+// It is meant to be run with go generate:
+//
+//	//go:generate go run github.com/xremming/esox/cmd/esox-compress -dir static
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/xremming/esox"
+)
+
+type encoder struct {
+	ext string
+	new func(w io.Writer) io.WriteCloser
+}
+
+var encoders = []encoder{
+	{".br", func(w io.Writer) io.WriteCloser {
+		return brotli.NewWriterLevel(w, brotli.BestCompression)
+	}},
+	{".gz", func(w io.Writer) io.WriteCloser {
+		gz, _ := gzip.NewWriterLevel(w, gzip.BestCompression)
+		return gz
+	}},
+}
+
+const defaultExtensions = ".css,.js,.mjs,.json,.map,.svg,.html,.txt,.xml,.wasm,.ico"
+
+func main() {
+	dir := flag.String("dir", esox.StaticPrefix, "directory containing the static files")
+	extensions := flag.String("ext", defaultExtensions, "comma separated list of file extensions to compress")
+	minSize := flag.Int64("min-size", 512, "files smaller than this many bytes are not compressed")
+	force := flag.Bool("force", false, "recompress files even if the variants are up to date")
+	flag.Parse()
+
+	exts := make(map[string]bool)
+	for _, ext := range strings.Split(*extensions, ",") {
+		if ext = strings.TrimSpace(ext); ext != "" {
+			exts[ext] = true
+		}
+	}
+
+	err := run(*dir, exts, *minSize, *force)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "esox-compress:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, exts map[string]bool, minSize int64, force bool) error {
+	fsys := os.DirFS(dir)
+	manifest, err := esox.BuildStaticManifest(fsys)
+	if err != nil {
+		return err
+	}
+
+	return fs.WalkDir(fsys, ".", func(staticPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() || !exts[path.Ext(staticPath)] {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		file, err := manifest.Open(staticPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", staticPath, err)
+		}
+
+		content, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", staticPath, err)
+		}
+
+		if int64(len(content)) < minSize {
+			return nil
+		}
+
+		// Stylesheets are served with the hashed paths of the files they
+		// refer to, so their variants can be stale even when they are newer.
+		stylesheet := path.Ext(staticPath) == ".css"
+
+		for _, enc := range encoders {
+			variantPath := filepath.Join(dir, filepath.FromSlash(staticPath)) + enc.ext
+			if !force && !stylesheet && upToDate(variantPath, info) {
+				continue
+			}
+
+			err := compress(variantPath, content, info.Mode().Perm(), enc, force)
+			if err != nil {
+				return fmt.Errorf("%s: %w", staticPath, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// upToDate reports whether the variant is at least as new as the file.
+func upToDate(variantPath string, info fs.FileInfo) bool {
+	variantInfo, err := os.Stat(variantPath)
+	return err == nil && !variantInfo.ModTime().Before(info.ModTime())
+}
+
+func compress(variantPath string, content []byte, perm fs.FileMode, enc encoder, force bool) error {
+	var buf bytes.Buffer
+	w := enc.new(&buf)
+	if _, err := w.Write(content); err != nil {
+		return err
+	}
+
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	// A variant that is not smaller than the original is useless, remove any
+	// stale one so that the original is served instead.
+	if buf.Len() >= len(content) {
+		err := os.Remove(variantPath)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		return nil
+	}
+
+	if !force {
+		existing, err := os.ReadFile(variantPath)
+		if err == nil && bytes.Equal(existing, buf.Bytes()) {
+			return nil
+		}
+	}
+
+	return os.WriteFile(variantPath, buf.Bytes(), perm)
+}
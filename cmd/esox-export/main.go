@@ -0,0 +1,86 @@
+// Command esox-export writes every static file and bundle under its hashed
+// name to a directory, ready to be synced to the asset host set with
+// App.AssetHost. The Content-Type and Cache-Control of each file are written
+// to a sidecar JSON file so that they can be set when uploading:
+//
+//	go run github.com/xremming/esox/cmd/esox-export -out dist/static -metadata dist/static.json
+//	aws s3 sync dist/static s3://bucket/static/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/xremming/esox"
+)
+
+func main() {
+	dir := flag.String("dir", esox.StaticPrefix, "directory containing the static files")
+	out := flag.String("out", "dist/static", "directory the static files are written to")
+	metadata := flag.String("metadata", "dist/static.json", "file the metadata of the written files is written to")
+	noMinify := flag.Bool("no-minify", false, "do not minify the bundles")
+
+	var opts esox.StaticOptions
+	flag.Func("bundle", "bundle as path=part1,part2,...", func(value string) error {
+		bundle, err := esox.ParseBundle(value)
+		opts.Bundles = append(opts.Bundles, bundle)
+		return err
+	})
+	flag.Func("sri", "comma separated list of SRI algorithms, for example sha384", func(value string) error {
+		algorithms, err := esox.ParseSRIAlgorithms(value)
+		opts.SRIAlgorithms = algorithms
+		return err
+	})
+	flag.Func("mime", "content type of an extension as .ext=type, like App.MIMETypes", func(value string) error {
+		ext, contentType, ok := strings.Cut(value, "=")
+		if !ok || !strings.HasPrefix(ext, ".") || contentType == "" {
+			return fmt.Errorf("invalid content type %q, expected .ext=type", value)
+		}
+
+		if opts.MIMETypes == nil {
+			opts.MIMETypes = make(map[string]string)
+		}
+
+		opts.MIMETypes[ext] = contentType
+		return nil
+	})
+	flag.Parse()
+
+	for i := range opts.Bundles {
+		opts.Bundles[i].NoMinify = *noMinify
+	}
+
+	err := run(*dir, *out, *metadata, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "esox-export:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, out, metadata string, opts esox.StaticOptions) error {
+	manifest, err := opts.BuildManifest(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+
+	exported, err := manifest.Export(out)
+	if err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(exported, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(metadata), 0o755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(metadata, append(content, '\n'), 0o644)
+}
@@ -0,0 +1,65 @@
+// Command esox-manifest hashes every file in a static directory and writes
+// the result as a JSON manifest, which can be loaded on startup by setting
+// App.StaticManifestFile. Bundles are given with -bundle, which may be
+// repeated, and must match App.Bundles, just like -sri must match
+// App.SRIAlgorithms. It is meant to be run with go generate:
+//
+//	//go:generate go run github.com/xremming/esox/cmd/esox-manifest -dir static -out static-manifest.json -bundle app.css=reset.css,layout.css
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/xremming/esox"
+)
+
+func main() {
+	dir := flag.String("dir", esox.StaticPrefix, "directory containing the static files")
+	out := flag.String("out", "static-manifest.json", "file the manifest is written to")
+	noMinify := flag.Bool("no-minify", false, "do not minify the bundles")
+
+	var opts esox.StaticOptions
+	flag.Func("bundle", "bundle as path=part1,part2,...", func(value string) error {
+		bundle, err := esox.ParseBundle(value)
+		opts.Bundles = append(opts.Bundles, bundle)
+		return err
+	})
+	flag.Func("sri", "comma separated list of SRI algorithms, for example sha384", func(value string) error {
+		algorithms, err := esox.ParseSRIAlgorithms(value)
+		opts.SRIAlgorithms = algorithms
+		return err
+	})
+	flag.Parse()
+
+	for i := range opts.Bundles {
+		opts.Bundles[i].NoMinify = *noMinify
+	}
+
+	err := run(*dir, *out, opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "esox-manifest:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir, out string, opts esox.StaticOptions) error {
+	manifest, err := opts.BuildManifest(os.DirFS(dir))
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	err = manifest.WriteJSON(file)
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
@@ -124,3 +124,10 @@ func (csrf CSRF) Validate(ctx context.Context, token string) error {
 
 	return nil
 }
+
+// ValidateRequest validates the CSRF token of the request, read from the _csrf
+// form value or query parameter, or from the X-CSRF-Token or X-XSRF-Token
+// header, which is the way to send it with a JSON request body.
+func (csrf CSRF) ValidateRequest(r *http.Request) error {
+	return csrf.Validate(r.Context(), getCSRF(r))
+}
@@ -7,18 +7,77 @@ import (
 
 type locationKey struct{}
 
+// GetLocation returns the location of the app, UTC if the context has not
+// been set up by an App.
 func GetLocation(ctx context.Context) *time.Location {
-	return ctx.Value(locationKey{}).(*time.Location)
+	value, ok := ctx.Value(locationKey{}).(*time.Location)
+	if !ok {
+		return time.UTC
+	}
+
+	return value
 }
 
 type nameMappingKey struct{}
 
+// GetNameMapping returns the URLs of the app by their name, nil if the
+// context has not been set up by an App.
 func GetNameMapping(ctx context.Context) map[string]URL {
-	return ctx.Value(nameMappingKey{}).(map[string]URL)
+	value, _ := ctx.Value(nameMappingKey{}).(map[string]URL)
+	return value
 }
 
 type runConfigKey struct{}
 
+// GetRunConfig returns the configuration the app is run with, the zero
+// RunConfig if the context has not been set up by an App.
 func GetRunConfig(ctx context.Context) RunConfig {
-	return ctx.Value(runConfigKey{}).(RunConfig)
+	value, _ := ctx.Value(runConfigKey{}).(RunConfig)
+	return value
+}
+
+type staticConfigKey struct{}
+
+func getStaticConfig(ctx context.Context) staticConfig {
+	value := ctx.Value(staticConfigKey{})
+	if value == nil {
+		return staticConfig{}
+	}
+
+	return value.(staticConfig)
+}
+
+type staticManifestKey struct{}
+
+// GetStaticManifest returns the static manifest of the app. If the context has
+// no manifest a lazily hashing one reading from StaticPrefix is returned.
+func GetStaticManifest(ctx context.Context) *StaticManifest {
+	value := ctx.Value(staticManifestKey{})
+	if value == nil {
+		return defaultStaticManifest
+	}
+
+	return value.(*StaticManifest)
+}
+
+type templatesKey struct{}
+
+// GetTemplates returns the templates of the app. If the context has no
+// templates the files are read from TemplatesPrefix.
+func GetTemplates(ctx context.Context) *Templates {
+	value := ctx.Value(templatesKey{})
+	if value == nil {
+		return defaultTemplates
+	}
+
+	return value.(*Templates)
+}
+
+type nonceKey struct{}
+
+// GetNonce returns the CSP nonce of the request, empty unless
+// Security.CSPNonce is set.
+func GetNonce(ctx context.Context) string {
+	value, _ := ctx.Value(nonceKey{}).(string)
+	return value
 }
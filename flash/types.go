@@ -17,6 +17,6 @@ var flashLevels = map[byte]Level{
 }
 
 type Data struct {
-	Level   Level
-	Message string
+	Level   Level  `json:"level"`
+	Message string `json:"message"`
 }
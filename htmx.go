@@ -0,0 +1,134 @@
+package esox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/xremming/esox/flash"
+)
+
+// HTMXFlashEvent is the event triggered with the HX-Trigger header to show the
+// flashes of an htmx request answered with a fragment, unless the App has a
+// FlashPartial. The event detail has the flashes as its value, for example
+//
+//	document.body.addEventListener("flash", (event) => showToasts(event.detail.value))
+const HTMXFlashEvent = "flash"
+
+// isHTMXRequest reports whether the request was made by htmx.
+func isHTMXRequest(r *http.Request) bool {
+	return r.Header.Get("HX-Request") == "true"
+}
+
+// HTMXBlock sets the block rendered instead of the whole page for htmx
+// requests whose HX-Target is not one of the HTMXTargets.
+func (t *Template) HTMXBlock(name string) *Template {
+	t.htmxBlock = name
+	return t
+}
+
+// HTMXTargets sets the blocks of the page an htmx request may ask for by
+// their name in its HX-Target header. The header is set by the client, so
+// only blocks which can be shown to anyone who can see the page should be
+// listed.
+func (t *Template) HTMXTargets(names ...string) *Template {
+	t.htmxTargets = names
+	return t
+}
+
+// fragment returns the block of the page an htmx request is answered with,
+// or an empty string when the whole page is rendered. Boosted requests and
+// history restores swap the whole body, so they get the whole page.
+func (t *Template) fragment(r *http.Request, page *template.Template) string {
+	if !isHTMXRequest(r) || r.Header.Get("HX-Boosted") == "true" || r.Header.Get("HX-History-Restore-Request") == "true" {
+		return ""
+	}
+
+	target := r.Header.Get("HX-Target")
+	if slices.Contains(t.htmxTargets, target) && page.Lookup(target) != nil {
+		return target
+	}
+
+	return t.htmxBlock
+}
+
+// htmxRedirect tells htmx to navigate to the URL. htmx follows 3xx responses
+// transparently and swaps in the page redirected to, so the redirect is sent
+// as a header instead. Local URLs are loaded by htmx like a boosted link,
+// others with a full page load.
+func htmxRedirect(w http.ResponseWriter, url string) {
+	if strings.HasPrefix(url, "/") && !strings.HasPrefix(url, "//") {
+		w.Header().Set("HX-Location", url)
+	} else {
+		w.Header().Set("HX-Redirect", url)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// htmxTrigger adds the event to the HX-Trigger header, keeping the events the
+// handler has already set in either the JSON or the comma-separated form.
+func htmxTrigger(w http.ResponseWriter, event string, detail any) error {
+	events := make(map[string]any)
+
+	existing := strings.TrimSpace(w.Header().Get("HX-Trigger"))
+	if strings.HasPrefix(existing, "{") {
+		err := json.Unmarshal([]byte(existing), &events)
+		if err != nil {
+			return err
+		}
+	} else if existing != "" {
+		for _, name := range strings.Split(existing, ",") {
+			events[strings.TrimSpace(name)] = nil
+		}
+	}
+
+	events[event] = detail
+
+	value, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("HX-Trigger", string(value))
+	return nil
+}
+
+type flashPartialKey struct{}
+
+func getFlashPartial(ctx context.Context) string {
+	value, _ := ctx.Value(flashPartialKey{}).(string)
+	return value
+}
+
+// fragmentFlashes delivers the flashes of a request answered with a fragment,
+// which would drop them as only the full page shows them. With a flash
+// partial they are rendered and appended to the fragment, the partial should
+// use hx-swap-oob to swap them to their place in the page. Otherwise they are
+// sent as the HTMXFlashEvent.
+func fragmentFlashes(ctx context.Context, w http.ResponseWriter, ts *Templates, buf *bytes.Buffer, flashes []flash.Data) error {
+	if len(flashes) == 0 {
+		return nil
+	}
+
+	partial := getFlashPartial(ctx)
+	if partial == "" {
+		return htmxTrigger(w, HTMXFlashEvent, flashes)
+	}
+
+	file, err := ts.file(partial)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := ts.bind(ctx, file.tmpl)
+	if err != nil {
+		return err
+	}
+
+	return tmpl.Execute(buf, flashes)
+}
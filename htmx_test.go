@@ -0,0 +1,115 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xremming/esox/flash"
+)
+
+func TestTemplateRenderHTMX(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html": {Data: []byte(`<main>{{ block "content" . }}{{ end }}</main>`)},
+		"index.html": {Data: []byte(
+			`{{ define "content" }}<ul id="list">{{ template "list" . }}</ul>{{ end }}` +
+				`{{ define "list" }}<li>{{ .Title }}</li>{{ end }}` +
+				`{{ define "admin" }}secret{{ end }}`,
+		)},
+	}, false)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	tmpl := (&Template{name: "index.html", baseName: "base.html"}).HTMXBlock("content").HTMXTargets("list")
+
+	render := func(headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+
+		tmpl.Render(w, r, http.StatusOK, &testRenderData{Title: "a"})
+		return w
+	}
+
+	page := render(nil)
+	assert.Equal(t, `<main><ul id="list"><li>a</li></ul></main>`, page.Body.String())
+	assert.Equal(t, []string{"HX-Request, HX-Target"}, page.Header().Values("Vary"))
+
+	list := render(map[string]string{"HX-Request": "true", "HX-Target": "list"})
+	assert.Equal(t, `<li>a</li>`, list.Body.String())
+	assert.NotEqual(t, page.Header().Get("ETag"), list.Header().Get("ETag"))
+
+	content := render(map[string]string{"HX-Request": "true", "HX-Target": "other"})
+	assert.Equal(t, `<ul id="list"><li>a</li></ul>`, content.Body.String())
+
+	// Blocks which are not listed in HTMXTargets cannot be asked for.
+	admin := render(map[string]string{"HX-Request": "true", "HX-Target": "admin"})
+	assert.Equal(t, `<ul id="list"><li>a</li></ul>`, admin.Body.String())
+
+	boosted := render(map[string]string{"HX-Request": "true", "HX-Boosted": "true"})
+	assert.Equal(t, page.Body.String(), boosted.Body.String())
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	tmpl.RenderBlock(w, r, http.StatusOK, "list", &testRenderData{Title: "b"})
+	assert.Equal(t, `<li>b</li>`, w.Body.String())
+}
+
+func TestRedirectHTMX(t *testing.T) {
+	for url, header := range map[string]string{
+		"/users":                "HX-Location",
+		"//example.com/users":   "HX-Redirect",
+		"https://example.com/x": "HX-Redirect",
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("HX-Request", "true")
+
+		Redirect(w, r, url, http.StatusSeeOther)
+		assert.Equal(t, http.StatusNoContent, w.Code, url)
+		assert.Equal(t, url, w.Header().Get(header), url)
+	}
+
+	w := httptest.NewRecorder()
+	Redirect(w, httptest.NewRequest(http.MethodPost, "/", nil), "/users", http.StatusSeeOther)
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/users", w.Header().Get("Location"))
+}
+
+func TestTemplateRenderHTMXFlashes(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html":     {Data: []byte(`{{ block "content" . }}{{ end }}`)},
+		"index.html":    {Data: []byte(`{{ define "content" }}<p>{{ .Title }}</p>{{ end }}`)},
+		"_flashes.html": {Data: []byte(`<div id="flashes" hx-swap-oob="true">{{ range . }}<p class="{{ .Level }}">{{ .Message }}</p>{{ end }}</div>`)},
+	}, false)
+	require.NoError(t, err)
+
+	tmpl := &Template{name: "index.html", baseName: "base.html"}
+	render := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		w.Header().Set("HX-Trigger", "saved")
+
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r = r.WithContext(flash.NewContext(ctx, []flash.Data{{Level: flash.LevelSuccess, Message: "Saved"}}))
+		r.Header.Set("HX-Request", "true")
+
+		tmpl.RenderBlock(w, r, http.StatusOK, "content", &testRenderData{Title: "a"})
+		return w
+	}
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+
+	w := render(ctx)
+	assert.Equal(t, `<p>a</p>`, w.Body.String())
+	assert.JSONEq(t, `{"saved": null, "flash": [{"level": "success", "message": "Saved"}]}`, w.Header().Get("HX-Trigger"))
+
+	w = render(context.WithValue(ctx, flashPartialKey{}, "_flashes.html"))
+	assert.Equal(t, `<p>a</p><div id="flashes" hx-swap-oob="true"><p class="success">Saved</p></div>`, w.Body.String())
+	assert.Equal(t, "saved", w.Header().Get("HX-Trigger"))
+}
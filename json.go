@@ -0,0 +1,208 @@
+package esox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/hlog"
+	"github.com/xremming/esox/csrf"
+	"github.com/xremming/esox/utils"
+)
+
+// DefaultMaxJSONBytes is the maximum size of a request body DecodeJSON reads
+// when App.MaxJSONBytes is not set.
+const DefaultMaxJSONBytes int64 = 1 << 20
+
+// RenderJSON writes the value as JSON. Like Template.Render, a 200 response
+// gets an ETag, and a Cache-Control and Last-Modified if the value has the
+// CacheControl and ModTime methods of RenderData, so conditional requests are
+// answered with 304 Not Modified.
+func RenderJSON(w http.ResponseWriter, r *http.Request, code int, v any) {
+	buf := utils.GetBytesBuffer()
+	defer utils.PutBytesBuffer(buf)
+
+	err := json.NewEncoder(buf).Encode(v)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Int("code", code).Msg("failed to encode JSON")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	writeRendered(w, r, code, "", v, buf.Bytes(), "application/json")
+}
+
+// FieldError is a problem with a single field of a JSON request body. Field
+// is the dotted path of the field, for example "address.city", except for a
+// field the value does not have, which encoding/json only reports by its own
+// name, for example "city".
+type FieldError struct {
+	Field  string `json:"field"`
+	Detail string `json:"detail"`
+}
+
+// Problem is an RFC 9457 problem details object describing why a request
+// failed. DecodeJSON returns its errors as a *Problem, which RenderProblem
+// writes as application/problem+json.
+type Problem struct {
+	Type   string       `json:"type,omitempty"`
+	Title  string       `json:"title"`
+	Status int          `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+	Errors []FieldError `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Title
+	}
+
+	return p.Title + ": " + p.Detail
+}
+
+// RenderProblem writes the error as application/problem+json. Errors which
+// are not a *Problem are logged and written as an Internal Server Error
+// without their details.
+func RenderProblem(w http.ResponseWriter, r *http.Request, err error) {
+	var problem *Problem
+	if !errors.As(err, &problem) {
+		hlog.FromRequest(r).Err(err).Msg("request failed")
+		problem = &Problem{Title: "Internal Server Error", Status: http.StatusInternalServerError}
+	}
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("failed to encode problem")
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	_, err = w.Write(body)
+	if err != nil {
+		hlog.FromRequest(r).Err(err).Msg("Failed to write response body.")
+	}
+}
+
+// JSONValidator can be implemented by the values decoded with DecodeJSON to
+// report the problems with the decoded fields.
+type JSONValidator interface {
+	ValidateJSON() []FieldError
+}
+
+type maxJSONBytesKey struct{}
+
+func getMaxJSONBytes(ctx context.Context) int64 {
+	value, _ := ctx.Value(maxJSONBytesKey{}).(int64)
+	if value <= 0 {
+		return DefaultMaxJSONBytes
+	}
+
+	return value
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+
+	return false
+}
+
+// DecodeJSON decodes the JSON request body into v, rejecting bodies which are
+// too large, have fields v does not have, or have more than one value. If v
+// implements JSONValidator it is validated as well. When the App has CSRF
+// protection, requests with an unsafe method must send the token in the
+// X-CSRF-Token header. The errors returned are *Problem, so they can be
+// written with RenderProblem.
+func DecodeJSON(r *http.Request, v any) error {
+	ctx := r.Context()
+
+	if csrfStruct := csrf.FromContext(ctx); csrfStruct != nil && !isSafeMethod(r.Method) {
+		err := csrfStruct.ValidateRequest(r)
+		if err != nil {
+			return &Problem{Title: "Invalid CSRF token", Status: http.StatusForbidden, Detail: err.Error()}
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return &Problem{
+			Title:  "Unsupported Media Type",
+			Status: http.StatusUnsupportedMediaType,
+			Detail: "The request body must be application/json.",
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(nil, r.Body, getMaxJSONBytes(ctx)))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		return &Problem{
+			Title:  "Invalid JSON",
+			Status: http.StatusBadRequest,
+			Detail: "The request body must contain a single JSON value.",
+		}
+	} else if err != nil {
+		return jsonProblem(err)
+	}
+
+	if validator, ok := v.(JSONValidator); ok {
+		if errs := validator.ValidateJSON(); len(errs) > 0 {
+			return &Problem{Title: "Invalid request", Status: http.StatusBadRequest, Errors: errs}
+		}
+	}
+
+	return nil
+}
+
+// jsonProblem describes the error returned by json.Decoder.Decode.
+func jsonProblem(err error) *Problem {
+	var (
+		syntaxErr   *json.SyntaxError
+		typeErr     *json.UnmarshalTypeError
+		maxBytesErr *http.MaxBytesError
+	)
+
+	invalid := &Problem{Title: "Invalid JSON", Status: http.StatusBadRequest}
+
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return &Problem{
+			Title:  "Request body too large",
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("The request body must not be larger than %d bytes.", maxBytesErr.Limit),
+		}
+	case errors.Is(err, io.EOF):
+		invalid.Detail = "The request body is empty."
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		invalid.Detail = "The request body ends unexpectedly."
+	case errors.As(err, &syntaxErr):
+		invalid.Detail = fmt.Sprintf("The request body has a syntax error at byte %d.", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		invalid.Errors = []FieldError{{
+			Field:  typeErr.Field,
+			Detail: fmt.Sprintf("must be of type %s, not %s", typeErr.Type, typeErr.Value),
+		}}
+	default:
+		// The decoder has no typed error for unknown fields, and its message
+		// only has the name of the field, not its path.
+		field, ok := strings.CutPrefix(err.Error(), "json: unknown field ")
+		if !ok {
+			invalid.Detail = err.Error()
+			break
+		}
+
+		invalid.Errors = []FieldError{{Field: strings.Trim(field, `"`), Detail: "unknown field"}}
+	}
+
+	return invalid
+}
@@ -0,0 +1,116 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xremming/esox/csrf"
+)
+
+type testJSONUser struct {
+	Name    string `json:"name"`
+	Age     int    `json:"age"`
+	Address struct {
+		City string `json:"city"`
+	} `json:"address"`
+}
+
+func (u testJSONUser) ValidateJSON() []FieldError {
+	if u.Name == "" {
+		return []FieldError{{Field: "name", Detail: "is required"}}
+	}
+
+	return nil
+}
+
+func (testJSONUser) CacheControl() (bool, time.Duration) { return false, time.Hour }
+
+func TestRenderJSON(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderJSON(w, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusOK, testJSONUser{Name: "a"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "private, max-age=3600", w.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{"name": "a", "age": 0, "address": {"city": ""}}`, w.Body.String())
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", w.Header().Get("ETag"))
+	w = httptest.NewRecorder()
+	RenderJSON(w, r, http.StatusOK, testJSONUser{Name: "a"})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+}
+
+func TestDecodeJSON(t *testing.T) {
+	decode := func(ctx context.Context, contentType string, body string) (testJSONUser, error) {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body)).WithContext(ctx)
+		r.Header.Set("Content-Type", contentType)
+
+		var user testJSONUser
+		err := DecodeJSON(r, &user)
+		return user, err
+	}
+
+	ctx := context.Background()
+
+	user, err := decode(ctx, "application/json; charset=utf-8", `{"name": "a", "address": {"city": "b"}}`)
+	require.NoError(t, err)
+	assert.Equal(t, "b", user.Address.City)
+
+	for body, expected := range map[string]Problem{
+		`{"name": "a", "address": {"city": 1}}`: {Title: "Invalid JSON", Status: 400, Errors: []FieldError{{Field: "address.city", Detail: "must be of type string, not number"}}},
+		`{"name": "a", "email": "x"}`:           {Title: "Invalid JSON", Status: 400, Errors: []FieldError{{Field: "email", Detail: "unknown field"}}},
+		`{"name": "a", "address": {"zip": 1}}`:  {Title: "Invalid JSON", Status: 400, Errors: []FieldError{{Field: "zip", Detail: "unknown field"}}},
+		`{"name": "a"} {}`:                      {Title: "Invalid JSON", Status: 400, Detail: "The request body must contain a single JSON value."},
+		`{"name": }`:                            {Title: "Invalid JSON", Status: 400, Detail: "The request body has a syntax error at byte 10."},
+		``:                                      {Title: "Invalid JSON", Status: 400, Detail: "The request body is empty."},
+		`{}`:                                    {Title: "Invalid request", Status: 400, Errors: []FieldError{{Field: "name", Detail: "is required"}}},
+		`{"name": "` + strings.Repeat("a", 100) + `"}`: {Title: "Request body too large", Status: 413, Detail: "The request body must not be larger than 64 bytes."},
+	} {
+		_, err := decode(context.WithValue(ctx, maxJSONBytesKey{}, int64(64)), "application/json", body)
+
+		var problem *Problem
+		require.ErrorAs(t, err, &problem, body)
+		assert.Equal(t, expected, *problem, body)
+	}
+
+	_, err = decode(ctx, "text/plain", `{}`)
+	assert.Equal(t, http.StatusUnsupportedMediaType, err.(*Problem).Status)
+
+	protected := csrf.CSRF{Secrets: []string{"secret"}}
+	_, err = decode(csrf.NewContext(ctx, &protected), "application/json", `{"name": "a"}`)
+	assert.Equal(t, http.StatusForbidden, err.(*Problem).Status)
+
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"name": "a"}`))
+	r = r.WithContext(csrf.NewContext(ctx, &protected))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("X-CSRF-Token", protected.Generate())
+	assert.NoError(t, DecodeJSON(r, &user))
+}
+
+func TestRenderProblem(t *testing.T) {
+	w := httptest.NewRecorder()
+	RenderProblem(w, httptest.NewRequest(http.MethodPost, "/", nil), &Problem{
+		Title:  "Invalid request",
+		Status: http.StatusBadRequest,
+		Errors: []FieldError{{Field: "name", Detail: "is required"}},
+	})
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, "application/problem+json", w.Header().Get("Content-Type"))
+	assert.JSONEq(t,
+		`{"title": "Invalid request", "status": 400, "errors": [{"field": "name", "detail": "is required"}]}`,
+		w.Body.String(),
+	)
+
+	w = httptest.NewRecorder()
+	RenderProblem(w, httptest.NewRequest(http.MethodPost, "/", nil), assert.AnError)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, w.Body.String(), assert.AnError.Error())
+}
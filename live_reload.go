@@ -0,0 +1,286 @@
+package esox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// LiveReloadPath is the URL path of the event stream pages rendered in dev
+// mode connect to, to be reloaded when the templates or static files change.
+const LiveReloadPath = "/_esox/live-reload"
+
+const liveReloadInterval = 500 * time.Millisecond
+
+// liveReloadScript reloads the page on a reload event, and swaps the
+// stylesheets marked with data-esox-static to their new URLs on a css event.
+// The server sends its start time as a hello event, so the page is also
+// reloaded when the server restarts.
+const liveReloadScript = `(function () {
+	var started;
+	var source = new EventSource("` + LiveReloadPath + `");
+	source.addEventListener("hello", function (event) {
+		if (started && started !== event.data) location.reload();
+		started = event.data;
+	});
+	source.addEventListener("reload", function () {
+		location.reload();
+	});
+	source.addEventListener("css", function (event) {
+		var assets = JSON.parse(event.data);
+		document.querySelectorAll("link[data-esox-static]").forEach(function (link) {
+			var asset = assets[link.getAttribute("data-esox-static")];
+			if (!asset || link.getAttribute("href") === asset.href) return;
+			var next = link.cloneNode();
+			next.setAttribute("href", asset.href);
+			next.setAttribute("integrity", asset.integrity);
+			next.onload = function () { link.remove(); };
+			link.after(next);
+		});
+	});
+})();`
+
+var liveReloadScriptTag = "<script>" + liveReloadScript + "</script>"
+
+// liveReloadScriptHash is the CSP source which allows the inline script.
+var liveReloadScriptHash = cspHash([]byte(liveReloadScript))
+
+// liveReloadCSP adds the hash of the live reload script to the directive the
+// scripts are governed by.
+func liveReloadCSP(csp string) string {
+	return addCSPSource(csp, "script-src", liveReloadScriptHash)
+}
+
+// injectLiveReload inserts the live reload script before the closing body
+// tag of the page, or at the end if there is none.
+func injectLiveReload(page []byte) []byte {
+	index := strings.LastIndex(strings.ToLower(string(page)), "</body>")
+	if index == -1 {
+		return append(page, liveReloadScriptTag...)
+	}
+
+	out := make([]byte, 0, len(page)+len(liveReloadScriptTag))
+	out = append(out, page[:index]...)
+	out = append(out, liveReloadScriptTag...)
+	return append(out, page[index:]...)
+}
+
+type liveReloadEvent struct {
+	name string
+	data string
+}
+
+type liveReloadFile struct {
+	modTime time.Time
+	size    int64
+}
+
+// liveReload polls the templates and static files for changes and sends the
+// changes as events to the connected pages.
+type liveReload struct {
+	templates fs.FS
+	static    fs.FS
+	manifest  *StaticManifest
+	config    staticConfig
+	started   string
+
+	mu      sync.Mutex
+	clients map[chan liveReloadEvent]struct{}
+}
+
+func newLiveReload(templates fs.FS, static fs.FS, manifest *StaticManifest, config staticConfig) *liveReload {
+	return &liveReload{
+		templates: templates,
+		static:    static,
+		manifest:  manifest,
+		config:    config,
+		started:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		clients:   make(map[chan liveReloadEvent]struct{}),
+	}
+}
+
+// snapshot returns the files of both file systems keyed by their path,
+// prefixed with static/ or templates/.
+func (lr *liveReload) snapshot() map[string]liveReloadFile {
+	out := make(map[string]liveReloadFile)
+	for prefix, fsys := range map[string]fs.FS{"templates/": lr.templates, "static/": lr.static} {
+		_ = fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+
+			out[prefix+name] = liveReloadFile{modTime: info.ModTime(), size: info.Size()}
+			return nil
+		})
+	}
+
+	return out
+}
+
+// changedFiles returns the paths of the files which were added, removed or
+// modified between the snapshots.
+func changedFiles(before, after map[string]liveReloadFile) []string {
+	var out []string
+	for name, file := range after {
+		if prev, ok := before[name]; !ok || !prev.modTime.Equal(file.modTime) || prev.size != file.size {
+			out = append(out, name)
+		}
+	}
+
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			out = append(out, name)
+		}
+	}
+
+	return out
+}
+
+// event returns the event for the changed files. When only stylesheets have
+// changed the page does not have to be reloaded, the stylesheets are swapped
+// to their new URLs instead.
+func (lr *liveReload) event(changed []string) (liveReloadEvent, error) {
+	for _, name := range changed {
+		if !strings.HasPrefix(name, "static/") || !strings.HasSuffix(name, ".css") {
+			return liveReloadEvent{name: "reload"}, nil
+		}
+	}
+
+	paths, err := lr.manifest.Paths()
+	if err != nil {
+		return liveReloadEvent{}, err
+	}
+
+	type stylesheet struct {
+		Href      string `json:"href"`
+		Integrity string `json:"integrity"`
+	}
+
+	stylesheets := make(map[string]stylesheet)
+	for _, p := range paths {
+		if !strings.HasSuffix(p, ".css") {
+			continue
+		}
+
+		asset, err := lr.manifest.Get(p)
+		if err != nil {
+			return liveReloadEvent{}, err
+		}
+
+		stylesheets[p] = stylesheet{Href: lr.config.url(asset.PathWithHash), Integrity: asset.Integrity}
+	}
+
+	data, err := json.Marshal(stylesheets)
+	if err != nil {
+		return liveReloadEvent{}, err
+	}
+
+	return liveReloadEvent{name: "css", data: string(data)}, nil
+}
+
+func (lr *liveReload) broadcast(event liveReloadEvent) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+
+	for client := range lr.clients {
+		select {
+		case client <- event:
+		default:
+			// The client is behind, it gets the next event.
+		}
+	}
+}
+
+// watch polls for changes until the context is done.
+func (lr *liveReload) watch(ctx context.Context, log zerolog.Logger) {
+	ticker := time.NewTicker(liveReloadInterval)
+	defer ticker.Stop()
+
+	files := lr.snapshot()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		current := lr.snapshot()
+		changed := changedFiles(files, current)
+		files = current
+
+		if len(changed) == 0 {
+			continue
+		}
+
+		event, err := lr.event(changed)
+		if err != nil {
+			log.Err(err).Msg("Failed to build live reload event, reloading instead.")
+			event = liveReloadEvent{name: "reload"}
+		}
+
+		log.Debug().Strs("files", changed).Str("event", event.name).Msg("Files changed, sending live reload event.")
+		lr.broadcast(event)
+	}
+}
+
+// handler streams the events to a page until the page or the context is
+// done.
+func (lr *liveReload) handler(ctx context.Context) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		client := make(chan liveReloadEvent, 1)
+
+		lr.mu.Lock()
+		lr.clients[client] = struct{}{}
+		lr.mu.Unlock()
+
+		defer func() {
+			lr.mu.Lock()
+			delete(lr.clients, client)
+			lr.mu.Unlock()
+		}()
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-store")
+
+		rc := http.NewResponseController(w)
+		event := liveReloadEvent{name: "hello", data: lr.started}
+		for {
+			_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+			if err == nil {
+				err = rc.Flush()
+			}
+
+			if err != nil {
+				return
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.Context().Done():
+				return
+			case event = <-client:
+			}
+		}
+	}
+}
+
+type liveReloadKey struct{}
+
+func getLiveReload(ctx context.Context) bool {
+	value, _ := ctx.Value(liveReloadKey{}).(bool)
+	return value
+}
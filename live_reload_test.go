@@ -0,0 +1,99 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiveReloadCSP(t *testing.T) {
+	assert.Equal(t, "default-src 'self' "+liveReloadScriptHash, liveReloadCSP("default-src 'self'"))
+	assert.Equal(t,
+		"default-src 'self'; script-src 'self' "+liveReloadScriptHash+"; img-src *",
+		liveReloadCSP("default-src 'self'; script-src 'self'; img-src *"),
+	)
+	assert.Equal(t, "script-src 'unsafe-inline'", liveReloadCSP("script-src 'unsafe-inline'"))
+	assert.Equal(t, "img-src *", liveReloadCSP("img-src *"))
+}
+
+func TestInjectLiveReload(t *testing.T) {
+	assert.Equal(t, "<body>x"+liveReloadScriptTag+"</BODY>", string(injectLiveReload([]byte("<body>x</BODY>"))))
+	assert.Equal(t, "x"+liveReloadScriptTag, string(injectLiveReload([]byte("x"))))
+}
+
+func TestLiveReloadEvent(t *testing.T) {
+	static := fstest.MapFS{"css/site.css": {Data: []byte("body {}")}}
+	manifest := NewDevStaticManifest(static)
+
+	site, err := manifest.Get("css/site.css")
+	require.NoError(t, err)
+
+	lr := newLiveReload(fstest.MapFS{}, static, manifest, staticConfig{})
+
+	event, err := lr.event([]string{"static/css/site.css"})
+	require.NoError(t, err)
+	assert.Equal(t, "css", event.name)
+	assert.JSONEq(t,
+		`{"css/site.css": {"href": "/static/`+site.PathWithHash+`", "integrity": "`+site.Integrity+`"}}`,
+		event.data,
+	)
+
+	event, err = lr.event([]string{"static/css/site.css", "templates/index.html"})
+	require.NoError(t, err)
+	assert.Equal(t, "reload", event.name)
+}
+
+func TestLiveReloadChangedFiles(t *testing.T) {
+	before := map[string]liveReloadFile{
+		"static/a.css": {modTime: time.Unix(1, 0), size: 1},
+		"static/b.css": {modTime: time.Unix(1, 0), size: 1},
+	}
+	after := map[string]liveReloadFile{
+		"static/a.css":         {modTime: time.Unix(2, 0), size: 1},
+		"templates/index.html": {modTime: time.Unix(1, 0), size: 1},
+	}
+
+	assert.ElementsMatch(t, []string{"static/a.css", "static/b.css", "templates/index.html"}, changedFiles(before, after))
+}
+
+func TestLiveReloadHandler(t *testing.T) {
+	lr := newLiveReload(fstest.MapFS{}, fstest.MapFS{}, nil, staticConfig{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, LiveReloadPath, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lr.handler(ctx)(w, r)
+	}()
+
+	require.Eventually(t, func() bool {
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+		return len(lr.clients) == 1
+	}, time.Second, time.Millisecond)
+
+	lr.broadcast(liveReloadEvent{name: "reload"})
+	require.Eventually(t, func() bool {
+		lr.mu.Lock()
+		defer lr.mu.Unlock()
+		for client := range lr.clients {
+			return len(client) == 0
+		}
+		return false
+	}, time.Second, time.Millisecond)
+
+	cancel()
+	<-done
+
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	assert.Equal(t, "event: hello\ndata: "+lr.started+"\n\nevent: reload\ndata: \n\n", w.Body.String())
+}
@@ -0,0 +1,133 @@
+package esox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// JSONRenderData can be implemented by RenderData to choose what is rendered
+// when the page is requested as JSON, instead of the RenderData itself.
+// Fields of the RenderData can also be left out with the `json:"-"` tag.
+type JSONRenderData interface {
+	RenderJSON() any
+}
+
+// acceptQuality returns the quality the Accept header gives to the media
+// type, zero if it is not acceptable.
+func acceptQuality(accept string, mediaType string) float64 {
+	typ, _, _ := strings.Cut(mediaType, "/")
+
+	best, bestSpecificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaRange := strings.ToLower(strings.TrimSpace(params[0]))
+
+		var specificity int
+		switch mediaRange {
+		case mediaType:
+			specificity = 2
+		case typ + "/*":
+			specificity = 1
+		case "*/*":
+			specificity = 0
+		default:
+			continue
+		}
+
+		q := 1.0
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if key == "q" {
+				if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+
+		// The most specific media range decides the quality.
+		if specificity > bestSpecificity {
+			best, bestSpecificity = q, specificity
+		}
+	}
+
+	return best
+}
+
+type jsonRequestKey struct{}
+
+// jsonRequest is how a request to a URL with JSON set may ask for JSON.
+type jsonRequest int
+
+const (
+	// jsonAccept requests are answered with JSON when their Accept header
+	// prefers it.
+	jsonAccept jsonRequest = iota + 1
+	// jsonAlways requests were made to the .json path of the URL.
+	jsonAlways
+)
+
+func getJSONRequest(ctx context.Context) jsonRequest {
+	value, _ := ctx.Value(jsonRequestKey{}).(jsonRequest)
+	return value
+}
+
+// wantsJSON reports whether the page should be rendered as JSON, either
+// because it was requested from the .json path of a URL with JSON set, or
+// because the URL has JSON set and the Accept header prefers JSON over HTML.
+// Pages of other URLs are never rendered as JSON.
+func wantsJSON(r *http.Request) bool {
+	switch getJSONRequest(r.Context()) {
+	case jsonAlways:
+		return true
+	case jsonAccept:
+		accept := r.Header.Get("Accept")
+		if accept == "" {
+			return false
+		}
+
+		return acceptQuality(accept, "application/json") > acceptQuality(accept, "text/html")
+	}
+
+	return false
+}
+
+// jsonPath returns the path the JSON of the page at the URL path is served
+// from, for example /users.json for /users and /index.json for /. A path
+// ending with a wildcard has no such path.
+func jsonPath(urlPath string) (string, error) {
+	trimmed := strings.TrimSuffix(urlPath, "/")
+	if strings.HasSuffix(trimmed, "}") {
+		return "", fmt.Errorf("URL path %q ending with a wildcard cannot have a .json path", urlPath)
+	}
+
+	if trimmed == "" || strings.HasSuffix(trimmed, " ") {
+		return trimmed + "/index.json", nil
+	}
+
+	return trimmed + ".json", nil
+}
+
+// jsonHandler marks the requests to the handler as possibly asking for JSON
+// the given way.
+func jsonHandler(value jsonRequest) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), jsonRequestKey{}, value)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func encodeRenderData(w io.Writer, data RenderData) error {
+	var v any = data
+	if jsonData, ok := data.(JSONRenderData); ok {
+		v = jsonData.RenderJSON()
+	}
+
+	return json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,136 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xremming/esox/flash"
+)
+
+func TestWantsJSON(t *testing.T) {
+	for accept, expected := range map[string]bool{
+		"":                                  false,
+		"*/*":                               false,
+		"application/json":                  true,
+		"application/json, text/html;q=0.9": true,
+		"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8": false,
+		"text/html;q=0, */*":                true,
+		"application/*;q=0.5, text/*;q=0.4": true,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		assert.False(t, wantsJSON(r), accept)
+
+		r = r.WithContext(context.WithValue(r.Context(), jsonRequestKey{}, jsonAccept))
+		assert.Equal(t, expected, wantsJSON(r), accept)
+
+		r = r.WithContext(context.WithValue(r.Context(), jsonRequestKey{}, jsonAlways))
+		assert.True(t, wantsJSON(r), accept)
+	}
+}
+
+func TestJSONPath(t *testing.T) {
+	for urlPath, expected := range map[string]string{
+		"/":                     "/index.json",
+		"/users":                "/users.json",
+		"/users/":               "/users.json",
+		"GET /":                 "GET /index.json",
+		"GET /users/{id}/posts": "GET /users/{id}/posts.json",
+	} {
+		out, err := jsonPath(urlPath)
+		require.NoError(t, err, urlPath)
+		assert.Equal(t, expected, out, urlPath)
+	}
+
+	for _, urlPath := range []string{"/users/{id}", "/users/{id}/", "/files/{path...}", "/{$}"} {
+		_, err := jsonPath(urlPath)
+		assert.Error(t, err, urlPath)
+	}
+
+	app := App{
+		StaticResources: fstest.MapFS{},
+		URLs:            URLs{{Name: "user", Path: "/users/{id}", Handler: http.NotFoundHandler(), JSON: true}},
+	}
+	ctx, err := app.Context(context.Background(), RunConfig{})
+	require.NoError(t, err)
+
+	_, err = app.Handler(ctx)
+	assert.ErrorContains(t, err, "URL user")
+}
+
+type testJSONData struct {
+	Title   string       `json:"title"`
+	Flashes []flash.Data `json:"-"`
+}
+
+func (testJSONData) ModTime() time.Time                  { return time.Unix(1, 0) }
+func (testJSONData) CacheControl() (bool, time.Duration) { return true, time.Minute }
+func (d *testJSONData) SetFlashes(flashes []flash.Data)  { d.Flashes = flashes }
+
+type testCustomJSONData struct {
+	testJSONData
+}
+
+func (d testCustomJSONData) RenderJSON() any {
+	return []string{d.Title}
+}
+
+func TestTemplateRenderJSON(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html":  {Data: []byte(`{{ template "content" . }}`)},
+		"index.html": {Data: []byte(`{{ define "content" }}<h1>{{ .Title }}</h1>{{ end }}`)},
+	}, false)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	tmpl := &Template{name: "index.html", baseName: "base.html"}
+
+	// Without URL.JSON the page is always rendered as HTML.
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	r.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	tmpl.Render(w, r, http.StatusOK, &testJSONData{Title: "Home"})
+	assert.Equal(t, "<h1>Home</h1>", w.Body.String())
+	assert.NotContains(t, w.Header().Values("Vary"), "Accept")
+
+	ctx = context.WithValue(ctx, jsonRequestKey{}, jsonAccept)
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	tmpl.Render(w, r, http.StatusOK, &testJSONData{Title: "Home"})
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=60", w.Header().Get("Cache-Control"))
+	assert.Contains(t, w.Header().Values("Vary"), "Accept")
+	assert.JSONEq(t, `{"title": "Home"}`, w.Body.String())
+
+	etag := w.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	r = httptest.NewRequest(http.MethodGet, "/index.json", nil)
+	r = r.WithContext(context.WithValue(ctx, jsonRequestKey{}, jsonAlways))
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	tmpl.Render(w, r, http.StatusOK, &testJSONData{Title: "Home"})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	r.Header.Set("Accept", "application/json")
+	w = httptest.NewRecorder()
+	tmpl.Render(w, r, http.StatusNotFound, &testCustomJSONData{testJSONData{Title: "Home"}})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.JSONEq(t, `["Home"]`, w.Body.String())
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w = httptest.NewRecorder()
+	tmpl.Render(w, r, http.StatusOK, &testJSONData{Title: "Home"})
+	assert.Equal(t, "<h1>Home</h1>", w.Body.String())
+}
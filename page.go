@@ -0,0 +1,150 @@
+package esox
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/xremming/esox/csrf"
+	"github.com/xremming/esox/flash"
+)
+
+// Meta is a meta tag of a page, rendered as
+// <meta name="Name" content="Content">.
+type Meta struct {
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// Page implements RenderData, embed it in the data of a page instead of
+// implementing the methods by hand:
+//
+//	type UsersPage struct {
+//		esox.Page
+//		Users []User
+//	}
+//
+// The flashes and the CSP nonce are set when the page is rendered, so
+// templates can use them as .Flashes and .Nonce. A CSRF token is given by the
+// csrfToken template func.
+type Page struct {
+	Title   string
+	Meta    []Meta       `json:",omitempty"`
+	Flashes []flash.Data `json:",omitempty"`
+
+	// Nonce is the CSP nonce of the request, empty unless Security.CSPNonce
+	// is set.
+	Nonce string `json:"-"`
+
+	LastModified time.Time     `json:"-"`
+	Public       bool          `json:"-"`
+	MaxAge       time.Duration `json:"-"`
+}
+
+func (p *Page) ModTime() time.Time {
+	return p.LastModified
+}
+
+func (p *Page) CacheControl() (public bool, maxAge time.Duration) {
+	return p.Public, p.MaxAge
+}
+
+func (p *Page) SetFlashes(flashes []flash.Data) {
+	p.Flashes = flashes
+}
+
+func (p *Page) page() *Page {
+	return p
+}
+
+// pageData is implemented by the data embedding Page.
+type pageData interface {
+	page() *Page
+}
+
+// setupPage sets the fields of the Page which come from the request.
+func setupPage(ctx context.Context, data any) {
+	pd, ok := data.(pageData)
+	if !ok {
+		return
+	}
+
+	pd.page().Nonce = GetNonce(ctx)
+}
+
+// pageTokens records the tokens issued while a page is rendered.
+type pageTokens struct {
+	csrf bool
+}
+
+type pageTokensKey struct{}
+
+func getPageTokens(ctx context.Context) *pageTokens {
+	value, _ := ctx.Value(pageTokensKey{}).(*pageTokens)
+	return value
+}
+
+// csrfToken returns a new CSRF token, empty when the App has no CSRF
+// protection, and records that the page has one.
+func csrfToken(ctx context.Context) string {
+	csrfStruct := csrf.FromContext(ctx)
+	if csrfStruct == nil {
+		return ""
+	}
+
+	if tokens := getPageTokens(ctx); tokens != nil {
+		tokens.csrf = true
+	}
+
+	return csrfStruct.Generate()
+}
+
+// isRequestSpecific reports whether the response has a CSP nonce or a CSRF
+// token, which must not be shared with other requests. Such a response is
+// neither cached publicly nor given an ETag, which would never match.
+func isRequestSpecific(ctx context.Context) bool {
+	if GetNonce(ctx) != "" {
+		return true
+	}
+
+	tokens := getPageTokens(ctx)
+	return tokens != nil && tokens.csrf
+}
+
+func newNonce() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// cspHash returns the CSP source which allows the inline script or style with
+// the content.
+func cspHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return "'sha256-" + base64.StdEncoding.EncodeToString(sum[:]) + "'"
+}
+
+// addCSPSource adds the source to the directive, or to default-src if the
+// CSP has no such directive. Directives allowing 'unsafe-inline' are left as
+// they are, as a hash or a nonce would disable it.
+func addCSPSource(csp, directive, source string) string {
+	directives := strings.Split(csp, ";")
+
+	index := -1
+	for i, d := range directives {
+		name, _, _ := strings.Cut(strings.TrimSpace(d), " ")
+		if name == directive || (name == "default-src" && index == -1) {
+			index = i
+		}
+	}
+
+	if index == -1 || strings.Contains(directives[index], "'unsafe-inline'") || strings.Contains(directives[index], source) {
+		return csp
+	}
+
+	directives[index] = strings.TrimRight(directives[index], " ") + " " + source
+	return strings.Join(directives, ";")
+}
@@ -0,0 +1,373 @@
+package esox
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/justinas/alice"
+)
+
+const (
+	DefaultPageCacheEntries    = 1000
+	DefaultPageCacheEntryBytes = 1 << 20
+)
+
+var (
+	// DefaultPageCacheVaryHeaders are the request headers the pages rendered
+	// by Template.Render vary by.
+	DefaultPageCacheVaryHeaders = []string{"Accept", "HX-Request", "HX-Target"}
+)
+
+// PageCache is an in-memory LRU cache of public pages, so that they are not
+// rendered again for every request missing the browser cache. A 200 response
+// to a GET request is stored when it has an ETag and a public Cache-Control
+// with a max-age or s-maxage, and it does not set cookies. It is served until
+// the max-age passes or the route is invalidated.
+//
+// Requests with an Authorization header or with cookies are never served
+// from or stored in the cache, like they are never cached publicly.
+type PageCache struct {
+	// MaxEntries is the number of pages kept, DefaultPageCacheEntries when 0.
+	MaxEntries int
+	// MaxEntryBytes is the size of the largest page kept,
+	// DefaultPageCacheEntryBytes when 0.
+	MaxEntryBytes int
+	// VaryHeaders are the request headers the stored responses may vary by,
+	// DefaultPageCacheVaryHeaders when nil. Responses varying by any other
+	// header are not stored. The pages of a route are only keyed by the
+	// headers its last stored response varied by.
+	VaryHeaders []string
+	// BypassCookies narrows the cookies which bypass the cache to the ones
+	// listed, for example the session cookie and "flash", so that requests
+	// with only other cookies, like a consent cookie, are served from the
+	// cache. Any cookie bypasses the cache when nil.
+	BypassCookies []string
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	// vary are the headers the last stored response of each route varied by.
+	vary map[string][]string
+}
+
+type pageCacheEntry struct {
+	key     string
+	route   string
+	header  http.Header
+	body    []byte
+	stored  time.Time
+	expires time.Time
+}
+
+func (c *PageCache) varyHeaders() []string {
+	if c.VaryHeaders == nil {
+		return DefaultPageCacheVaryHeaders
+	}
+
+	return c.VaryHeaders
+}
+
+func (c *PageCache) bypass(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return true
+	}
+
+	if r.Header.Get("Authorization") != "" {
+		return true
+	}
+
+	if c.BypassCookies == nil {
+		return r.Header.Get("Cookie") != ""
+	}
+
+	for _, name := range c.BypassCookies {
+		if _, err := r.Cookie(name); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// key identifies the page by the host, path, query and the headers the
+// response varies by. HEAD requests share the key of GET requests.
+func (c *PageCache) key(r *http.Request, vary []string) string {
+	var b strings.Builder
+	b.WriteString(r.Host)
+	b.WriteString(r.URL.Path)
+	b.WriteByte('?')
+	b.WriteString(r.URL.RawQuery)
+
+	for _, header := range vary {
+		b.WriteByte('\n')
+		b.WriteString(header)
+		b.WriteByte(':')
+		b.WriteString(strings.Join(r.Header.Values(header), ","))
+	}
+
+	return b.String()
+}
+
+func (c *PageCache) routeVary(name string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.vary[name]
+}
+
+func (c *PageCache) setRouteVary(name string, vary []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.vary == nil {
+		c.vary = make(map[string][]string)
+	}
+	c.vary[name] = vary
+}
+
+func (c *PageCache) get(key string, now time.Time) (pageCacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return pageCacheEntry{}, false
+	}
+
+	entry := elem.Value.(pageCacheEntry)
+	if !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return pageCacheEntry{}, false
+	}
+
+	c.lru.MoveToFront(elem)
+	return entry, true
+}
+
+func (c *PageCache) put(entry pageCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.lru = list.New()
+		c.entries = make(map[string]*list.Element)
+	}
+
+	if elem, ok := c.entries[entry.key]; ok {
+		c.lru.Remove(elem)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+
+	maxEntries := c.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultPageCacheEntries
+	}
+
+	for c.lru.Len() > maxEntries {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(pageCacheEntry).key)
+	}
+}
+
+// Invalidate removes the pages of the route with the name from the cache.
+func (c *PageCache) Invalidate(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if elem.Value.(pageCacheEntry).route == name {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// InvalidateAll removes every page from the cache.
+func (c *PageCache) InvalidateAll() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru = nil
+	c.entries = nil
+}
+
+// sharedMaxAge returns how long a shared cache may store the response, zero
+// if it may not.
+func sharedMaxAge(cacheControl string) time.Duration {
+	var public bool
+	maxAge, sMaxAge := -1, -1
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(name) {
+		case "public":
+			public = true
+		case "private", "no-store", "no-cache":
+			return 0
+		case "max-age":
+			maxAge, _ = strconv.Atoi(value)
+		case "s-maxage":
+			sMaxAge, _ = strconv.Atoi(value)
+		}
+	}
+
+	if sMaxAge >= 0 {
+		maxAge, public = sMaxAge, true
+	}
+
+	if !public || maxAge <= 0 {
+		return 0
+	}
+
+	return time.Duration(maxAge) * time.Second
+}
+
+// storable returns how long the response can be stored for, zero if it
+// cannot be.
+func (c *PageCache) storable(code int, header http.Header) time.Duration {
+	if code != http.StatusOK || header.Get("ETag") == "" || len(header.Values("Set-Cookie")) > 0 {
+		return 0
+	}
+
+	for _, vary := range responseVary(header) {
+		known := false
+		for _, header := range c.varyHeaders() {
+			known = known || strings.EqualFold(vary, header)
+		}
+
+		if !known {
+			return 0
+		}
+	}
+
+	return sharedMaxAge(header.Get("Cache-Control"))
+}
+
+// responseVary returns the canonical names of the request headers the
+// response varies by, sorted.
+func responseVary(header http.Header) []string {
+	var out []string
+	for _, value := range header.Values("Vary") {
+		for _, vary := range strings.Split(value, ",") {
+			vary = http.CanonicalHeaderKey(strings.TrimSpace(vary))
+			if vary != "" && !slices.Contains(out, vary) {
+				out = append(out, vary)
+			}
+		}
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// pageCacheRecorder passes the response through while keeping a copy of it.
+type pageCacheRecorder struct {
+	http.ResponseWriter
+	code     int
+	body     bytes.Buffer
+	tooLarge bool
+	maxBytes int
+}
+
+func (rec *pageCacheRecorder) WriteHeader(code int) {
+	if code >= 200 && rec.code == 0 {
+		rec.code = code
+	}
+
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *pageCacheRecorder) Write(b []byte) (int, error) {
+	if rec.code == 0 {
+		rec.code = http.StatusOK
+	}
+
+	if !rec.tooLarge {
+		if rec.body.Len()+len(b) > rec.maxBytes {
+			rec.tooLarge = true
+			rec.body = bytes.Buffer{}
+		} else {
+			rec.body.Write(b)
+		}
+	}
+
+	return rec.ResponseWriter.Write(b)
+}
+
+func (rec *pageCacheRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// Middleware caches the pages of the route with the name.
+func (c *PageCache) Middleware(name string) alice.Constructor {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if c.bypass(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			now := time.Now()
+			key := c.key(r, c.routeVary(name))
+
+			if entry, ok := c.get(key, now); ok {
+				for header, values := range entry.header {
+					w.Header()[header] = values
+				}
+
+				w.Header().Set("Age", strconv.Itoa(int(now.Sub(entry.stored).Seconds())))
+				w.Header().Set("X-Cache", "HIT")
+
+				lastModified, _ := http.ParseTime(entry.header.Get("Last-Modified"))
+				http.ServeContent(w, r, "", lastModified, bytes.NewReader(entry.body))
+				return
+			}
+
+			// A HEAD request has no body to store.
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			maxBytes := c.MaxEntryBytes
+			if maxBytes <= 0 {
+				maxBytes = DefaultPageCacheEntryBytes
+			}
+
+			// Without a validator the response is rendered in full, so it
+			// can be stored.
+			r.Header.Del("If-None-Match")
+			r.Header.Del("If-Modified-Since")
+
+			rec := &pageCacheRecorder{ResponseWriter: w, maxBytes: maxBytes}
+			next.ServeHTTP(rec, r)
+
+			maxAge := c.storable(rec.code, w.Header())
+			if maxAge <= 0 || rec.tooLarge {
+				return
+			}
+
+			vary := responseVary(w.Header())
+			c.setRouteVary(name, vary)
+
+			c.put(pageCacheEntry{
+				key:     c.key(r, vary),
+				route:   name,
+				header:  w.Header().Clone(),
+				body:    bytes.Clone(rec.body.Bytes()),
+				stored:  now,
+				expires: now.Add(maxAge),
+			})
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSharedMaxAge(t *testing.T) {
+	assert.Equal(t, time.Minute, sharedMaxAge("public, max-age=60"))
+	assert.Equal(t, time.Hour, sharedMaxAge("max-age=60, s-maxage=3600"))
+	assert.Equal(t, time.Duration(0), sharedMaxAge("max-age=60"))
+	assert.Equal(t, time.Duration(0), sharedMaxAge("private, max-age=60"))
+	assert.Equal(t, time.Duration(0), sharedMaxAge("public, no-cache, max-age=60"))
+}
+
+func TestPageCache(t *testing.T) {
+	renders := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renders++
+		RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute, Vary: []string{"Accept"}}})
+	})
+
+	cache := &PageCache{MaxEntries: 2}
+	cached := cache.Middleware("home")(handler)
+
+	get := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for key, value := range headers {
+			r.Header.Set(key, value)
+		}
+
+		cached.ServeHTTP(w, r)
+		return w
+	}
+
+	first := get("/", nil)
+	assert.Equal(t, http.StatusOK, first.Code)
+	assert.Empty(t, first.Header().Get("X-Cache"))
+
+	second := get("/", nil)
+	assert.Equal(t, "HIT", second.Header().Get("X-Cache"))
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, first.Header().Get("ETag"), second.Header().Get("ETag"))
+	assert.Equal(t, 1, renders)
+
+	assert.Equal(t, http.StatusNotModified, get("/", map[string]string{"If-None-Match": first.Header().Get("ETag")}).Code)
+	assert.Equal(t, 1, renders)
+
+	get("/", map[string]string{"Accept": "application/json"})
+	assert.Equal(t, 2, renders, "vary headers are part of the key")
+
+	get("/", map[string]string{"Cookie": "flash=x"})
+	get("/", map[string]string{"Authorization": "Bearer x"})
+	assert.Equal(t, 4, renders, "flash cookies and credentials bypass the cache")
+
+	get("/?page=2", nil)
+	assert.Equal(t, 5, renders)
+	get("/", nil)
+	assert.Equal(t, 6, renders, "the least recently used page is evicted")
+
+	cache.Invalidate("other")
+	get("/", nil)
+	assert.Equal(t, 6, renders)
+
+	cache.Invalidate("home")
+	get("/", nil)
+	assert.Equal(t, 7, renders)
+}
+
+func TestPageCacheNotStorable(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"private": func(w http.ResponseWriter, r *http.Request) {
+			RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{MaxAge: time.Minute}})
+		},
+		"not found": func(w http.ResponseWriter, r *http.Request) {
+			RenderJSON(w, r, http.StatusNotFound, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute}})
+		},
+		"unknown vary": func(w http.ResponseWriter, r *http.Request) {
+			RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute, Vary: []string{"Accept-Language"}}})
+		},
+		"sets cookie": func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, &http.Cookie{Name: "x", Value: "y"})
+			RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute}})
+		},
+		"nonce": func(w http.ResponseWriter, r *http.Request) {
+			r = r.WithContext(context.WithValue(r.Context(), nonceKey{}, "abc"))
+			RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute}})
+		},
+	} {
+		cached := (&PageCache{}).Middleware("home")(handler)
+		for i := 0; i < 2; i++ {
+			w := httptest.NewRecorder()
+			cached.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Empty(t, w.Header().Get("X-Cache"), name)
+		}
+	}
+}
+
+func TestPageCacheResponseVary(t *testing.T) {
+	renders := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renders++
+		RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute}})
+	})
+
+	cached := (&PageCache{}).Middleware("home")(handler)
+	for _, accept := range []string{"text/html", "*/*", "text/html", ""} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept", accept)
+		cached.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	assert.Equal(t, 1, renders, "only the headers the response varies by are part of the key")
+}
+
+func TestPageCacheBypassCookies(t *testing.T) {
+	renders := 0
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		renders++
+		RenderJSON(w, r, http.StatusOK, testCachePolicyData{CachePolicy{Public: true, MaxAge: time.Minute}})
+	})
+
+	get := func(cache *PageCache, cookie string) {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if cookie != "" {
+			r.Header.Set("Cookie", cookie)
+		}
+
+		cache.Middleware("home")(handler).ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	cache := &PageCache{}
+	get(cache, "")
+	get(cache, "sid=x")
+	assert.Equal(t, 2, renders, "any cookie bypasses the cache")
+
+	cache = &PageCache{BypassCookies: []string{"sid"}}
+	get(cache, "")
+	get(cache, "consent=yes")
+	assert.Equal(t, 3, renders, "only the listed cookies bypass the cache")
+	get(cache, "sid=x")
+	assert.Equal(t, 4, renders)
+}
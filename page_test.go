@@ -0,0 +1,133 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xremming/esox/csrf"
+)
+
+type testPage struct {
+	Page
+	Users []testUser
+	Tags  map[string]string
+}
+
+type testUser struct {
+	Name string
+}
+
+func (u testUser) Initial() string { return u.Name[:1] }
+
+func TestPageRender(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html": {Data: []byte(`<title>{{ .Title }}</title>{{ with .Nonce }}<script nonce="{{ . }}"></script>{{ end }}{{ template "content" . }}`)},
+		"users.html": {Data: []byte(`{{ define "content" }}{{ range .Users }}<p>{{ .Name }}</p>{{ end }}` +
+			`{{ if eq .Title "Form" }}{{ if csrfToken }}csrf{{ end }}{{ end }}{{ end }}`)},
+	}, false)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	ctx = csrf.NewContext(ctx, &csrf.CSRF{Secrets: []string{"secret"}})
+
+	tmpl := &TypedTemplate[*testPage]{t: &Template{name: "users.html", baseName: "base.html"}}
+	render := func(ctx context.Context, title string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		page := &testPage{Page: Page{Title: title, Public: true, MaxAge: time.Hour}, Users: []testUser{{Name: "a"}}}
+		tmpl.Render(w, r, http.StatusOK, page)
+		return w
+	}
+
+	// A page without a nonce or a CSRF token can be cached.
+	w := render(ctx, "Users")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `<title>Users</title><p>a</p>`, w.Body.String())
+	assert.NotEmpty(t, w.Header().Get("ETag"))
+	assert.Equal(t, "public, max-age=3600", w.Header().Get("Cache-Control"))
+
+	w = render(ctx, "Form")
+	assert.Equal(t, `<title>Form</title><p>a</p>csrf`, w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Equal(t, "private, max-age=3600", w.Header().Get("Cache-Control"))
+
+	w = render(context.WithValue(ctx, nonceKey{}, "abc"), "Users")
+	assert.Equal(t, `<title>Users</title><script nonce="abc"></script><p>a</p>`, w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Empty(t, w.Header().Get("Last-Modified"))
+	assert.Equal(t, "private, max-age=3600", w.Header().Get("Cache-Control"))
+}
+
+func TestPageRenderStreamingCSRF(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html":  {Data: []byte(`<head></head>{{ template "content" . }}`)},
+		"users.html": {Data: []byte(`{{ define "content" }}<form>{{ csrfToken }}</form>{{ end }}`)},
+	}, false)
+	require.NoError(t, err)
+
+	tmpl := (&Template{name: "users.html", baseName: "base.html"}).Streaming()
+	render := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		tmpl.Render(w, r, http.StatusOK, &testPage{Page: Page{Public: true, MaxAge: time.Hour}})
+		return w
+	}
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	assert.Equal(t, "public, max-age=3600", render(ctx).Header().Get("Cache-Control"))
+
+	// The headers are written before the token is issued in the body.
+	ctx = csrf.NewContext(ctx, &csrf.CSRF{Secrets: []string{"secret"}})
+	assert.Equal(t, "private, max-age=3600", render(ctx).Header().Get("Cache-Control"))
+}
+
+func TestAddCSPSource(t *testing.T) {
+	assert.Equal(t, "default-src 'self' 'nonce-a'", addCSPSource("default-src 'self'", "script-src", "'nonce-a'"))
+	assert.Equal(t, "default-src 'self' 'nonce-a'", addCSPSource("default-src 'self' 'nonce-a'", "style-src", "'nonce-a'"))
+	assert.Equal(t,
+		"default-src 'self'; script-src 'self' 'nonce-a'",
+		addCSPSource("default-src 'self'; script-src 'self'", "script-src", "'nonce-a'"),
+	)
+	assert.Equal(t, "script-src 'unsafe-inline'", addCSPSource("script-src 'unsafe-inline'", "script-src", "'nonce-a'"))
+	assert.Equal(t, "img-src 'self'", addCSPSource("img-src 'self'", "script-src", "'nonce-a'"))
+}
+
+func TestValidateTypes(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html": {Data: []byte(`<title>{{ .Titel }}</title>{{ template "content" . }}`)},
+		"users.html": {Data: []byte(`{{ define "content" }}` +
+			`{{ range $i, $u := .Users }}{{ $u.Name }}{{ .Initial }}{{ .Email }}{{ $.Users }}{{ end }}` +
+			`{{ with $tags := .Tags }}{{ .anything }}{{ $tags.x.Len }}{{ end }}` +
+			`{{ $first := index .Users 0 }}{{ $first.Whatever }}` +
+			`{{ partial "_user.html" (index .Users 0) }}{{ range .Users }}{{ partial "_user.html" . }}{{ end }}{{ partial "_flashes.html" .Flashes }}{{ end }}`)},
+		"_user.html":    {Data: []byte(`{{ .Name }}{{ .Age }}`)},
+		"_flashes.html": {Data: []byte(`{{ range . }}{{ .Message }}{{ .Text }}{{ end }}`)},
+	}, false)
+	require.NoError(t, err)
+
+	errs := validateTypes(ts, &Template{
+		name:     "users.html",
+		baseName: "base.html",
+		dataType: reflect.TypeFor[*testPage](),
+	})
+
+	var lines []string
+	for _, err := range errs {
+		lines = append(lines, err.Error())
+	}
+
+	assert.Equal(t, []string{
+		"base.html:1:10: .Titel: can't evaluate field Titel in type esox.testPage",
+		"users.html:1:80: .Email: can't evaluate field Email in type esox.testUser",
+		"users.html:1:159: $tags.x.Len: can't evaluate field Len in type string",
+		"_user.html:1:14: .Age: can't evaluate field Age in type esox.testUser",
+		"_flashes.html:1:30: .Text: can't evaluate field Text in type flash.Data",
+	}, lines)
+}
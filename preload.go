@@ -0,0 +1,137 @@
+package esox
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// Preload controls whether the assets referred to by a rendered template are
+// announced with Link headers before the response body.
+type Preload int
+
+const (
+	// PreloadNone does not add any Link headers.
+	PreloadNone Preload = iota
+	// PreloadLinkHeaders adds Link preload headers to the response.
+	PreloadLinkHeaders
+	// PreloadEarlyHints adds Link preload headers to the response and sends
+	// them in a 103 Early Hints response first. Early hints are only sent
+	// over HTTP/2 and newer, as browsers ignore them over HTTP/1.1 and some
+	// HTTP/1.1 clients and proxies fail on informational responses, so
+	// HTTP/1.1 clients only get the Link headers of the final response.
+	PreloadEarlyHints
+)
+
+type preloadLink struct {
+	url         string
+	rel         string
+	as          string
+	integrity   string
+	crossOrigin bool
+}
+
+func (l preloadLink) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%s>; rel=%s", l.url, l.rel)
+	if l.as != "" {
+		fmt.Fprintf(&b, "; as=%s", l.as)
+	}
+
+	// Browsers only use a preload for a script or stylesheet with an
+	// integrity attribute when the preload has the same integrity.
+	if l.integrity != "" {
+		fmt.Fprintf(&b, `; integrity="%s"`, l.integrity)
+	}
+
+	if l.crossOrigin {
+		b.WriteString("; crossorigin")
+	}
+
+	return b.String()
+}
+
+// preloadLinks collects the assets referred to while rendering a template.
+// A nil *preloadLinks ignores everything, which is the case when preloading
+// is not enabled.
+type preloadLinks struct {
+	links []preloadLink
+	seen  map[string]bool
+}
+
+func (p *preloadLinks) add(link preloadLink) {
+	if p == nil || p.seen[link.url] {
+		return
+	}
+
+	if p.seen == nil {
+		p.seen = make(map[string]bool)
+	}
+
+	p.seen[link.url] = true
+	p.links = append(p.links, link)
+}
+
+// writeHeaders adds the Link headers and sends the early hints if enabled.
+// It must be called before the status code is written.
+func (p *preloadLinks) writeHeaders(w http.ResponseWriter, r *http.Request, mode Preload) {
+	if p == nil || len(p.links) == 0 {
+		return
+	}
+
+	for _, link := range p.links {
+		w.Header().Add("Link", link.String())
+	}
+
+	if mode == PreloadEarlyHints && r.ProtoMajor >= 2 {
+		w.WriteHeader(http.StatusEarlyHints)
+	}
+}
+
+// staticPreload returns a preload link for the static file, which is also
+// added to the preloads. Fonts are always fetched in CORS mode, so they get
+// the crossorigin attribute even from the same origin.
+func staticPreload(manifest *StaticManifest, config staticConfig, preloads *preloadLinks, name string, as string) (template.HTML, error) {
+	asset, err := manifest.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	url := config.url(asset.PathWithHash)
+	crossOrigin := as == "font" || config.host != ""
+	preloads.add(preloadLink{url: url, rel: "preload", as: as, integrity: asset.Integrity, crossOrigin: crossOrigin})
+
+	crossOriginAttr := ""
+	if crossOrigin {
+		crossOriginAttr = ` crossorigin="anonymous"`
+	}
+
+	return template.HTML(fmt.Sprintf(
+		`<link rel="preload" href="%s" as="%s" integrity="%s"%s>`,
+		template.HTMLEscapeString(url), template.HTMLEscapeString(as), asset.Integrity, crossOriginAttr,
+	)), nil
+}
+
+type preloadKey struct{}
+
+func getPreload(ctx context.Context) Preload {
+	value := ctx.Value(preloadKey{})
+	if value == nil {
+		return PreloadNone
+	}
+
+	return value.(Preload)
+}
+
+type preloadLinksKey struct{}
+
+func getPreloadLinks(ctx context.Context) *preloadLinks {
+	value := ctx.Value(preloadLinksKey{})
+	if value == nil {
+		return nil
+	}
+
+	return value.(*preloadLinks)
+}
@@ -0,0 +1,49 @@
+package esox
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreloadLinks(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"site.css":     {Data: []byte("body {}")},
+		"fonts/a.woff": {Data: []byte("font")},
+	})
+	require.NoError(t, err)
+
+	site, err := manifest.Get("site.css")
+	require.NoError(t, err)
+
+	font, err := manifest.Get("fonts/a.woff")
+	require.NoError(t, err)
+
+	preloads := &preloadLinks{}
+	_, err = staticTags(manifest, staticConfig{}, preloads, "site.css", "style", "%s%s%s")
+	require.NoError(t, err)
+
+	tag, err := staticPreload(manifest, staticConfig{}, preloads, "fonts/a.woff", "font")
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<link rel="preload" href="/static/`+font.PathWithHash+`" as="font" integrity="`+font.Integrity+`" crossorigin="anonymous">`,
+		string(tag),
+	)
+
+	// The same file is only announced once.
+	_, err = staticTags(manifest, staticConfig{}, preloads, "site.css", "style", "%s%s%s")
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	preloads.writeHeaders(w, r, PreloadLinkHeaders)
+
+	assert.Equal(t, []string{
+		"</static/" + site.PathWithHash + `>; rel=preload; as=style; integrity="` + site.Integrity + `"`,
+		"</static/" + font.PathWithHash + `>; rel=preload; as=font; integrity="` + font.Integrity + `"; crossorigin`,
+	}, w.Header().Values("Link"))
+}
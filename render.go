@@ -9,55 +9,51 @@ import (
 	"html/template"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
+	"reflect"
 	"time"
 
 	"github.com/rs/zerolog/hlog"
+	"github.com/xremming/esox/csrf"
 	"github.com/xremming/esox/flash"
 	"github.com/xremming/esox/utils"
 )
 
+// Template is a page, a child template rendered in a base template, possibly
+// through layouts in between. The files are looked up from the Templates of
+// the app.
 type Template struct {
-	name          string
-	baseName      string
-	baseTemplate  string
-	childTemplate string
-}
-
-const TemplatesPrefix = "templates"
-
-func (t *Template) reload() {
-	baseFile, err := os.Open(filepath.Join(TemplatesPrefix, t.baseName))
-	if err != nil {
-		panic(err)
-	}
-	defer baseFile.Close()
-
-	childFile, err := os.Open(filepath.Join(TemplatesPrefix, t.name))
-	if err != nil {
-		panic(err)
-	}
-	defer childFile.Close()
+	name     string
+	baseName string
+	layouts  []string
 
-	baseContent, err := io.ReadAll(baseFile)
-	if err != nil {
-		panic(err)
-	}
-	t.baseTemplate = string(baseContent)
+	htmxBlock   string
+	htmxTargets []string
+	streaming   bool
 
-	childContent, err := io.ReadAll(childFile)
-	if err != nil {
-		panic(err)
-	}
-	t.childTemplate = string(childContent)
+	// dataType is the type of the data of a TypedTemplate, the field
+	// references of the page are checked against it by App.Validate.
+	dataType reflect.Type
 }
 
-func GetTemplate(name, baseName string) *Template {
-	out := Template{name: name, baseName: baseName}
-	out.reload()
+// GetTemplate returns the page template. The layouts extend the base template
+// in order and the page extends the last of them, each overriding the blocks
+// defined by the ones before it, for example
+//
+//	GetTemplate("users.html", "base.html", "dashboard.html")
+//
+// The files are not read until the page is first rendered. List the page in
+// App.Templates to have it built when the app starts instead, so that a
+// broken template prevents the app from starting.
+func GetTemplate(name, baseName string, layouts ...string) *Template {
+	return &Template{name: name, baseName: baseName, layouts: layouts}
+}
 
-	return &out
+// chain returns the files of the page in the order they are parsed in.
+func (t *Template) chain() []string {
+	out := make([]string, 0, len(t.layouts)+2)
+	out = append(out, t.baseName)
+	out = append(out, t.layouts...)
+	return append(out, t.name)
 }
 
 func checkFlashCookie(w http.ResponseWriter, r *http.Request) bool {
@@ -102,7 +98,7 @@ type RenderData interface {
 	SetFlashes(flashes []flash.Data)
 }
 
-func (t *Template) funcs(ctx context.Context) template.FuncMap {
+func templateFuncs(ctx context.Context, ts *Templates) template.FuncMap {
 	return template.FuncMap{
 		"now": func() time.Time {
 			location := GetLocation(ctx)
@@ -119,27 +115,18 @@ func (t *Template) funcs(ctx context.Context) template.FuncMap {
 			return t.Format(layout)
 		},
 		"partial": func(name string, data interface{}) (template.HTML, error) {
-			file, err := os.Open(filepath.Join(TemplatesPrefix, name))
-			if err != nil {
-				return "", err
-			}
-			defer file.Close()
-
-			buf := utils.GetBytesBuffer()
-			defer utils.PutBytesBuffer(buf)
-
-			_, err = io.Copy(buf, file)
+			file, err := ts.file(name)
 			if err != nil {
 				return "", err
 			}
 
-			tmpl, err := template.New(name).Funcs(t.funcs(ctx)).Parse(buf.String())
+			tmpl, err := ts.bind(ctx, file.tmpl)
 			if err != nil {
 				return "", err
 			}
 
-			// reuse the buffer for the output
-			buf.Reset()
+			buf := utils.GetBytesBuffer()
+			defer utils.PutBytesBuffer(buf)
 
 			err = tmpl.Execute(buf, data)
 			if err != nil {
@@ -148,29 +135,54 @@ func (t *Template) funcs(ctx context.Context) template.FuncMap {
 
 			return template.HTML(buf.String()), nil
 		},
-		"stylesheet": func(name string) (template.HTML, error) {
-			file, err := GetStaticFile(name)
-			if err != nil {
+		"stack": func(name string) (template.HTML, error) {
+			return stackPlaceholder(name)
+		},
+		"push": func(name string, content any) (string, error) {
+			if _, err := stackPlaceholder(name); err != nil {
 				return "", err
 			}
-			defer file.Close()
 
-			return template.HTML(fmt.Sprintf(
-				`<link rel="stylesheet" href="/static/%s" integrity="%s">`,
-				template.HTMLEscapeString(file.PathWithHash), file.Integrity,
-			)), nil
+			return "", getTemplateStacks(ctx).push(name, stackContent(content))
+		},
+		"csrfToken": func() string {
+			return csrfToken(ctx)
+		},
+		"flush": func() (string, error) {
+			if stream := getStreamWriter(ctx); stream != nil {
+				return "", stream.Flush()
+			}
+
+			return "", nil
+		},
+		"stylesheet": func(name string) (template.HTML, error) {
+			return staticTags(
+				GetStaticManifest(ctx), getStaticConfig(ctx), getPreloadLinks(ctx), name, "style",
+				`<link rel="stylesheet" href="%s" integrity="%s"%s>`,
+			)
 		},
 		"javascript": func(name string) (template.HTML, error) {
-			file, err := GetStaticFile(name)
+			return staticTags(
+				GetStaticManifest(ctx), getStaticConfig(ctx), getPreloadLinks(ctx), name, "script",
+				`<script src="%s" integrity="%s"%s></script>`,
+			)
+		},
+		"importmap": func(names ...string) (template.HTML, error) {
+			err := getStaticModules(ctx).writeImportMap()
 			if err != nil {
 				return "", err
 			}
-			defer file.Close()
 
-			return template.HTML(fmt.Sprintf(
-				`<script src="/static/%s" integrity="%s" async></script>`,
-				template.HTMLEscapeString(file.PathWithHash), file.Integrity,
-			)), nil
+			return staticImportMap(GetStaticManifest(ctx), getStaticConfig(ctx), getStaticModules(ctx), names)
+		},
+		"module": func(name string) (template.HTML, error) {
+			return staticModule(GetStaticManifest(ctx), getStaticConfig(ctx), getPreloadLinks(ctx), getStaticModules(ctx), name)
+		},
+		"preload": func(name string, as string) (template.HTML, error) {
+			return staticPreload(GetStaticManifest(ctx), getStaticConfig(ctx), getPreloadLinks(ctx), name, as)
+		},
+		"image": func(name string, alt string, sizes string, widths ...int) (template.HTML, error) {
+			return staticImage(GetStaticManifest(ctx), getStaticConfig(ctx), name, alt, sizes, widths)
 		},
 		"urlFor": func(name string) (string, error) {
 			nameMapping := GetNameMapping(ctx)
@@ -182,22 +194,80 @@ func (t *Template) funcs(ctx context.Context) template.FuncMap {
 			return url.Path, nil
 		},
 		"urlForStatic": func(name string) (string, error) {
-			file, err := GetStaticFile(name)
+			file, err := GetStaticManifest(ctx).Get(name)
 			if err != nil {
 				return "", err
 			}
-			defer file.Close()
 
-			return fmt.Sprintf("/static/%s", file.PathWithHash), nil
+			return getStaticConfig(ctx).url(file.PathWithHash), nil
 		},
 	}
 }
 
+// templateError responds with an Internal Server Error. In dev mode the
+// error itself is shown, so that it can be fixed without reading the logs.
+func templateError(w http.ResponseWriter, ts *Templates, err error) {
+	if ts.dev {
+		http.Error(w, fmt.Sprintf("Internal Server Error\n\n%s", err), http.StatusInternalServerError)
+		return
+	}
+
+	http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+}
+
+// Execute renders the page to w outside of a request, for example an email.
+// The context should come from App.Context, for the template funcs to have
+// the URLs and static files of the app.
+func (t *Template) Execute(ctx context.Context, w io.Writer, data any) error {
+	ts := GetTemplates(ctx)
+	page, err := ts.page(t)
+	if err != nil {
+		return err
+	}
+
+	setupPage(ctx, data)
+
+	stacks := &templateStacks{}
+	ctx = context.WithValue(ctx, templateStacksKey{}, stacks)
+	ctx = context.WithValue(ctx, staticModulesKey{}, &staticModules{})
+
+	tmpl, err := ts.bind(ctx, page)
+	if err != nil {
+		return err
+	}
+
+	buf := utils.GetBytesBuffer()
+	defer utils.PutBytesBuffer(buf)
+
+	err = tmpl.Execute(buf, data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(stacks.render(buf.Bytes()))
+	return err
+}
+
+// Render renders the page. For htmx requests only a block of the page is
+// rendered when the HX-Target is one of the HTMXTargets, or a block has been
+// set with HTMXBlock.
 func (t *Template) Render(w http.ResponseWriter, r *http.Request, code int, data RenderData) {
-	runConfig := GetRunConfig(r.Context())
-	if runConfig.Dev {
-		t.reload()
+	t.render(w, r, code, "", data)
+}
+
+// RenderBlock renders only the named block of the page, for example to
+// answer an htmx request which updates a part of the page.
+func (t *Template) RenderBlock(w http.ResponseWriter, r *http.Request, code int, blockName string, data RenderData) {
+	t.render(w, r, code, blockName, data)
+}
+
+func (t *Template) render(w http.ResponseWriter, r *http.Request, code int, block string, data RenderData) {
+	// The response depends on whether only a block of the page is rendered,
+	// and for URLs with JSON set, on whether it is JSON.
+	if getJSONRequest(r.Context()) != 0 {
+		w.Header().Add("Vary", "Accept")
 	}
+	w.Header().Add("Vary", "HX-Request, HX-Target")
 
 	log := hlog.FromRequest(r).With().
 		Int("code", code).
@@ -208,69 +278,179 @@ func (t *Template) Render(w http.ResponseWriter, r *http.Request, code int, data
 	flashes := flash.FromRequest(r)
 	setFlashCookie(w, r, false, flashes)
 	data.SetFlashes(flashes)
+	setupPage(r.Context(), data)
+
+	r = r.WithContext(context.WithValue(r.Context(), pageTokensKey{}, &pageTokens{}))
+
+	if wantsJSON(r) {
+		buf := utils.GetBytesBuffer()
+		defer utils.PutBytesBuffer(buf)
+
+		err := encodeRenderData(buf, data)
+		if err != nil {
+			log.Err(err).Msg("failed to encode render data")
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		writeRendered(w, r, code, t.name, data, buf.Bytes(), "application/json")
+		return
+	}
 
-	tmpl, err := template.New(t.baseName).
-		Funcs(t.funcs(r.Context())).
-		Parse(t.baseTemplate)
+	ctx := r.Context()
+	ts := GetTemplates(ctx)
+	page, err := ts.page(t)
 	if err != nil {
-		log.Err(err).Msg("failed to parse base template")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		log.Err(err).Msg("failed to parse template")
+		templateError(w, ts, err)
 		return
 	}
 
-	tmpl, err = tmpl.Parse(t.childTemplate)
+	if block == "" {
+		block = t.fragment(r, page)
+	}
+
+	// The assets of a fragment have already been loaded by the page.
+	preload := getPreload(ctx)
+	if block != "" {
+		preload = PreloadNone
+	}
+
+	var preloads *preloadLinks
+	if preload != PreloadNone {
+		preloads = &preloadLinks{}
+		ctx = context.WithValue(ctx, preloadLinksKey{}, preloads)
+	}
+
+	stacks := &templateStacks{}
+	ctx = context.WithValue(ctx, templateStacksKey{}, stacks)
+	// The import map of a fragment is in the page it is rendered into.
+	modules := &staticModules{importMap: block != "", nonce: GetNonce(ctx)}
+	ctx = context.WithValue(ctx, staticModulesKey{}, modules)
+
+	var stream *streamWriter
+	if t.streaming && block == "" {
+		// The headers are written before the body may issue a CSRF token.
+		if tokens := getPageTokens(ctx); tokens != nil && csrf.FromContext(ctx) != nil {
+			tokens.csrf = true
+		}
+
+		stream = &streamWriter{w: w, code: code, stacks: stacks}
+		stream.writeHeaders = func() {
+			applyCachePolicy(w, r, code, data)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			modules.writeCSP(w.Header())
+			preloads.writeHeaders(w, r, PreloadLinkHeaders)
+		}
+		ctx = context.WithValue(ctx, streamWriterKey{}, stream)
+	}
+
+	tmpl, err := ts.bind(ctx, page)
 	if err != nil {
-		log.Err(err).Msg("failed to parse child template")
+		log.Err(err).Msg("failed to clone template")
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
 
+	if stream != nil {
+		err = tmpl.Execute(stream, data)
+		if err != nil && !stream.started {
+			log.Err(err).Msg("failed to execute template")
+			templateError(w, ts, err)
+			return
+		} else if err != nil {
+			log.Err(err).Msg("failed to execute streamed template")
+			_, _ = fmt.Fprint(stream, streamErrorMarker(ts, err))
+		}
+
+		if getLiveReload(ctx) {
+			_, _ = fmt.Fprint(stream, liveReloadScriptTag)
+		}
+
+		err = stream.Flush()
+		if err != nil {
+			log.Err(err).Msg("Failed to write response body.")
+		}
+
+		return
+	}
+
 	buf := utils.GetBytesBuffer()
 	defer utils.PutBytesBuffer(buf)
 
-	h := sha256.New()
-	out := io.MultiWriter(buf, h)
-
-	err = tmpl.Execute(out, data)
+	if block != "" {
+		err = tmpl.ExecuteTemplate(buf, block, data)
+	} else {
+		err = tmpl.Execute(buf, data)
+	}
 	if err != nil {
-		log.Err(err).Msg("failed to execute template")
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		log.Err(err).Str("block", block).Msg("failed to execute template")
+		templateError(w, ts, err)
 		return
 	}
 
-	// When the code is not 200 we cannot be sure whether the content may be cached.
-	if code == http.StatusOK {
-		etag := fmt.Sprintf(`"%s"`, base64.URLEncoding.EncodeToString(h.Sum(nil)))
-		w.Header().Set("ETag", etag)
+	if block != "" {
+		err = fragmentFlashes(ctx, w, ts, buf, flashes)
+		if err != nil {
+			log.Err(err).Msg("failed to render flashes")
+			templateError(w, ts, err)
+			return
+		}
+	}
 
-		public, maxAge := data.CacheControl()
-		if maxAge > 0 {
-			prefix := "private "
-			if public {
-				prefix = ""
-			}
+	body := stacks.render(buf.Bytes())
+	if getLiveReload(ctx) && block == "" {
+		body = injectLiveReload(body)
+	}
 
-			cacheControl := fmt.Sprintf("%smax-age=%d", prefix, int(maxAge.Seconds()))
-			w.Header().Set("Cache-Control", cacheControl)
-		}
+	modules.writeCSP(w.Header())
+	preloads.writeHeaders(w, r, preload)
+	writeRendered(w, r, code, t.name, data, body, "text/html; charset=utf-8")
+}
+
+// writeRendered writes the rendered body with the cache policy of the data.
+// Unless the body is specific to the request, it is written with an ETag,
+// and with a Last-Modified if the data has the ModTime method of RenderData.
+func writeRendered(w http.ResponseWriter, r *http.Request, code int, name string, data any, body []byte, contentType string) {
+	// Conditional requests are only answered for 200 responses.
+	if code == http.StatusOK && !isRequestSpecific(r.Context()) {
+		sum := sha256.Sum256(body)
+		etag := fmt.Sprintf(`"%s"`, base64.URLEncoding.EncodeToString(sum[:]))
+		w.Header().Set("ETag", etag)
 	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	applyCachePolicy(w, r, code, data)
+
+	w.Header().Set("Content-Type", contentType)
 
 	// The http.ServeContent function is only guaranteed to work correctly when the status code is 200.
 	if code == http.StatusOK {
-		http.ServeContent(w, r, t.name, data.ModTime(), bytes.NewReader(buf.Bytes()))
+		var modTime time.Time
+		if data, ok := data.(interface{ ModTime() time.Time }); ok && !isRequestSpecific(r.Context()) {
+			modTime = data.ModTime()
+		}
+
+		http.ServeContent(w, r, name, modTime, bytes.NewReader(body))
 		return
 	}
 
 	w.WriteHeader(code)
-	_, err = io.Copy(w, buf)
+	_, err := w.Write(body)
 	if err != nil {
-		log.Err(err).Msg("Failed to write response body.")
+		hlog.FromRequest(r).Err(err).Msg("Failed to write response body.")
 	}
 }
 
+// Redirect redirects to the URL, keeping the flashes of the request for the
+// next one. Requests made by htmx are redirected with the HX-Location or
+// HX-Redirect header instead of a 3xx response.
 func Redirect(w http.ResponseWriter, r *http.Request, url string, code int) {
 	setFlashCookie(w, r, true, flash.FromRequest(r))
+
+	if isHTMXRequest(r) {
+		htmxRedirect(w, url)
+		return
+	}
+
 	http.Redirect(w, r, url, code)
 }
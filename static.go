@@ -1,56 +1,241 @@
 package esox
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
+	"mime"
 	"net/http"
-	"os"
 	"path"
-	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/rs/zerolog"
 )
 
 const StaticPrefix = "static"
 
-const hashLength = 2 * sha256.Size
+// DefaultStaticMount is the URL path the static files are served under.
+const DefaultStaticMount = "/static/"
+
+// DefaultStaticRootFiles are the static files and directories which are also
+// served from the root of the site, if they exist.
+var DefaultStaticRootFiles = []string{"favicon.ico", "robots.txt", ".well-known/"}
+
+// staticMIMETypes are the content types of the static files by extension.
+// They are used before mime.TypeByExtension, which depends on the system and
+// gets many of these wrong or does not know them at all.
+var staticMIMETypes = map[string]string{
+	".css":         "text/css; charset=utf-8",
+	".js":          "text/javascript; charset=utf-8",
+	".mjs":         "text/javascript; charset=utf-8",
+	".json":        "application/json",
+	".map":         "application/json",
+	".webmanifest": "application/manifest+json",
+	".svg":         "image/svg+xml",
+	".wasm":        "application/wasm",
+	".woff":        "font/woff",
+	".woff2":       "font/woff2",
+	".ttf":         "font/ttf",
+	".otf":         "font/otf",
+	".ico":         "image/x-icon",
+	".avif":        "image/avif",
+	".webp":        "image/webp",
+	".txt":         "text/plain; charset=utf-8",
+	".html":        "text/html; charset=utf-8",
+	".xml":         "application/xml",
+	".pdf":         "application/pdf",
+	".mp4":         "video/mp4",
+	".webm":        "video/webm",
+}
+
+// staticConfig is how the static files are referred to and served.
+type staticConfig struct {
+	// host is the scheme and host of the asset host, empty when the static
+	// files are served by the app itself.
+	host string
+	// mount is the URL path the static files are served under, empty for
+	// DefaultStaticMount.
+	mount string
+	// mimeTypes override the content types of the static files by extension.
+	mimeTypes map[string]string
+	// dev allows serving dotfiles and source maps.
+	dev bool
+}
+
+func (c staticConfig) mountPath() string {
+	if c.mount == "" {
+		return DefaultStaticMount
+	}
 
-func integrityHash(r io.Reader) (pathHash string, integrity string, err error) {
-	h := sha256.New()
+	return c.mount
+}
+
+func (c staticConfig) url(staticPath string) string {
+	return c.host + c.mountPath() + staticPath
+}
+
+// crossOriginAttr returns the crossorigin attribute needed for subresource
+// integrity to work when the static files are served from another origin.
+func (c staticConfig) crossOriginAttr() string {
+	if c.host == "" {
+		return ""
+	}
 
-	_, err = io.Copy(h, r)
+	return ` crossorigin="anonymous"`
+}
+
+// contentType returns the content type of the static file, sniffing it from
+// the content if the extension is unknown.
+func (c staticConfig) contentType(staticPath string, content io.ReadSeeker) (string, error) {
+	ext := strings.ToLower(path.Ext(staticPath))
+	if contentType, ok := c.mimeTypes[ext]; ok {
+		return contentType, nil
+	}
+
+	return staticContentType(staticPath, content)
+}
+
+// isHiddenStaticPath reports whether the static file is a dotfile, or inside a
+// dot directory, or a source map. Those are only served in dev mode.
+func isHiddenStaticPath(staticPath string) bool {
+	if path.Ext(staticPath) == ".map" {
+		return true
+	}
+
+	for _, segment := range strings.Split(staticPath, "/") {
+		if strings.HasPrefix(segment, ".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// hashLength is the length of the hex encoded, truncated SHA-256 fingerprint
+// added to the static file names. It is independent of the SRI algorithms.
+const hashLength = 16
+
+// legacyHashLength is the length of the untruncated fingerprint, which is
+// still recognized so that previously rendered URLs keep working.
+const legacyHashLength = 2 * sha256.Size
+
+// SRIAlgorithm is a hash algorithm used for subresource integrity.
+type SRIAlgorithm string
+
+const (
+	SHA256 SRIAlgorithm = "sha256"
+	SHA384 SRIAlgorithm = "sha384"
+	SHA512 SRIAlgorithm = "sha512"
+)
+
+// DefaultSRIAlgorithms are used when no SRI algorithms are configured.
+var DefaultSRIAlgorithms = []SRIAlgorithm{SHA256}
+
+func (alg SRIAlgorithm) newHash() (hash.Hash, error) {
+	switch alg {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA384:
+		return sha512.New384(), nil
+	case SHA512:
+		return sha512.New(), nil
+	}
+
+	return nil, fmt.Errorf("unknown SRI algorithm %q", alg)
+}
+
+// ParseSRIAlgorithms parses a comma separated list of SRI algorithms.
+func ParseSRIAlgorithms(value string) ([]SRIAlgorithm, error) {
+	var out []SRIAlgorithm
+	for _, name := range strings.Split(value, ",") {
+		alg := SRIAlgorithm(strings.ToLower(strings.TrimSpace(name)))
+		if _, err := alg.newHash(); err != nil {
+			return nil, err
+		}
+
+		out = append(out, alg)
+	}
+
+	return out, nil
+}
+
+// integrityHash returns the fingerprint used in the path of the static file
+// and its integrity, which lists a hash for each of the algorithms.
+func integrityHash(r io.Reader, algorithms []SRIAlgorithm) (pathHash string, integrity string, err error) {
+	if len(algorithms) == 0 {
+		algorithms = DefaultSRIAlgorithms
+	}
+
+	fingerprint := sha256.New()
+	writers := []io.Writer{fingerprint}
+	hashes := make([]hash.Hash, len(algorithms))
+	for i, alg := range algorithms {
+		hashes[i], err = alg.newHash()
+		if err != nil {
+			return
+		}
+
+		writers = append(writers, hashes[i])
+	}
+
+	_, err = io.Copy(io.MultiWriter(writers...), r)
 	if err != nil {
 		return
 	}
 
-	sum := h.Sum(nil)
-	pathHash = hex.EncodeToString(sum)
-	integrity = "sha256-" + base64.StdEncoding.EncodeToString(sum)
+	pathHash = hex.EncodeToString(fingerprint.Sum(nil))[:hashLength]
+
+	integrities := make([]string, len(algorithms))
+	for i, alg := range algorithms {
+		integrities[i] = string(alg) + "-" + base64.StdEncoding.EncodeToString(hashes[i].Sum(nil))
+	}
+	integrity = strings.Join(integrities, " ")
+
 	return
 }
 
-func normalizeStaticPath(staticPath string) string {
+func isPathHash(value string) bool {
+	if len(value) != hashLength && len(value) != legacyHashLength {
+		return false
+	}
+
+	_, err := hex.DecodeString(value)
+	return err == nil
+}
+
+// splitPathHash splits the segment which looks like a fingerprint off the
+// static path. Whether it really is the fingerprint of the file can only be
+// told by the manifest, see StaticManifest.resolve.
+func splitPathHash(staticPath string) (name string, hash string, ok bool) {
 	base := path.Base(staticPath)
 	splitted := strings.Split(base, ".")
 	if len(splitted) <= 2 {
-		return staticPath
+		return staticPath, "", false
 	}
 
-	hash := splitted[len(splitted)-2]
-	if len(hash) != hashLength {
-		return staticPath
+	hash = splitted[len(splitted)-2]
+	if !isPathHash(hash) {
+		return staticPath, "", false
 	}
 
 	before := strings.Join(splitted[:len(splitted)-2], ".")
 	after := splitted[len(splitted)-1]
 
-	return path.Join(path.Dir(staticPath), fmt.Sprintf("%s.%s", before, after))
+	return path.Join(path.Dir(staticPath), fmt.Sprintf("%s.%s", before, after)), hash, true
+}
+
+func normalizeStaticPath(staticPath string) string {
+	name, _, _ := splitPathHash(staticPath)
+	return name
 }
 
 func staticPathWithHash(staticPath string, hash string) (string, error) {
@@ -74,46 +259,211 @@ func staticPathWithHash(staticPath string, hash string) (string, error) {
 	return path.Join(path.Dir(staticPath), fmt.Sprintf("%s.%s.%s", before, hash, after)), nil
 }
 
+// StaticAsset describes a static file by its logical path, the path with the
+// content hash added and the subresource integrity of the content.
+type StaticAsset struct {
+	Path         string `json:"path"`
+	PathWithHash string `json:"pathWithHash"`
+	Hash         string `json:"hash"`
+	Integrity    string `json:"integrity"`
+
+	// Imports are the static files a JavaScript module imports.
+	Imports []string `json:"imports,omitempty"`
+	// Parts are the static files a bundle is made of.
+	Parts []string `json:"parts,omitempty"`
+	// Width and Height are the intrinsic size of a JPEG or PNG image.
+	Width  int `json:"width,omitempty"`
+	Height int `json:"height,omitempty"`
+}
+
 type StaticFile struct {
 	io.ReadCloser
-	Path         string
-	PathWithHash string
-	Integrity    string
+	StaticAsset
+	ModTime time.Time
 }
 
-func GetStaticFile(staticPath string) (StaticFile, error) {
-	normalized := normalizeStaticPath(staticPath)
-	file, err := os.Open(filepath.Join(StaticPrefix, normalized))
+func openStaticFile(fsys fs.FS, asset StaticAsset) (StaticFile, error) {
+	file, err := fsys.Open(asset.Path)
 	if err != nil {
 		return StaticFile{}, err
 	}
 
-	pathHash, integrity, err := integrityHash(file)
+	info, err := file.Stat()
 	if err != nil {
+		file.Close()
 		return StaticFile{}, err
 	}
 
-	// after hash is calculated we need to reset the file pointer to the beginning
-	_, err = file.Seek(0, io.SeekStart)
+	return StaticFile{ReadCloser: file, StaticAsset: asset, ModTime: info.ModTime()}, nil
+}
+
+func hashStaticContent(staticPath string, r io.Reader, algorithms []SRIAlgorithm) (StaticAsset, error) {
+	pathHash, integrity, err := integrityHash(r, algorithms)
 	if err != nil {
-		return StaticFile{}, err
+		return StaticAsset{}, err
 	}
 
 	pathWithHash, err := staticPathWithHash(staticPath, pathHash)
 	if err != nil {
-		return StaticFile{}, err
+		return StaticAsset{}, err
 	}
 
-	return StaticFile{
-		ReadCloser:   file,
-		Path:         normalized,
+	return StaticAsset{
+		Path:         staticPath,
 		PathWithHash: pathWithHash,
+		Hash:         pathHash,
 		Integrity:    integrity,
-	}, err
+	}, nil
+}
+
+func hashStaticFile(fsys fs.FS, staticPath string, algorithms []SRIAlgorithm) (StaticAsset, error) {
+	file, err := fsys.Open(staticPath)
+	if err != nil {
+		return StaticAsset{}, err
+	}
+	defer file.Close()
+
+	return hashStaticContent(staticPath, file, algorithms)
+}
+
+// GetStaticFile opens the static file from the StaticPrefix directory. Prefer
+// GetStaticManifest(ctx).Open, which uses the static files of the app.
+func GetStaticFile(staticPath string) (StaticFile, error) {
+	return defaultStaticManifest.Open(staticPath)
+}
+
+// staticEncodings are the precompressed variants looked up next to a static
+// file, in order of preference. They can be generated with cmd/esox-compress.
+var staticEncodings = []struct {
+	name string
+	ext  string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
 }
 
-func staticHandler(w http.ResponseWriter, r *http.Request) {
-	path := strings.TrimPrefix(r.URL.Path, "/static/")
+// acceptsEncoding reports whether the Accept-Encoding header value allows the
+// given content coding.
+func acceptsEncoding(acceptEncoding string, encoding string) bool {
+	wildcard := false
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(param, "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil {
+				q = parsed
+			}
+		}
+
+		if strings.EqualFold(name, encoding) {
+			return q > 0
+		}
+
+		if name == "*" {
+			wildcard = q > 0
+		}
+	}
+
+	return wildcard
+}
+
+// openPrecompressed opens the most preferred precompressed variant of the
+// static file which the client accepts. The found return value reports
+// whether any variant exists at all, even if none of them was acceptable.
+func openPrecompressed(fsys fs.FS, staticPath string, acceptEncoding string) (file fs.File, encoding string, found bool) {
+	for _, enc := range staticEncodings {
+		variantPath := staticPath + enc.ext
+		if _, err := fs.Stat(fsys, variantPath); err != nil {
+			continue
+		}
+
+		found = true
+		if file != nil || !acceptsEncoding(acceptEncoding, enc.name) {
+			continue
+		}
+
+		f, err := fsys.Open(variantPath)
+		if err != nil {
+			continue
+		}
+
+		file, encoding = f, enc.name
+	}
+
+	return
+}
+
+// asReadSeeker returns r as an io.ReadSeeker, reading it fully into memory if
+// it cannot seek by itself.
+func asReadSeeker(r io.Reader) (io.ReadSeeker, error) {
+	if rs, ok := r.(io.ReadSeeker); ok {
+		return rs, nil
+	}
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(content), nil
+}
+
+func staticContentType(staticPath string, content io.ReadSeeker) (string, error) {
+	ext := strings.ToLower(path.Ext(staticPath))
+	if contentType, ok := staticMIMETypes[ext]; ok {
+		return contentType, nil
+	}
+
+	if contentType := mime.TypeByExtension(ext); contentType != "" {
+		return contentType, nil
+	}
+
+	var buf [512]byte
+	n, err := io.ReadFull(content, buf[:])
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
+	}
+
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return "", err
+	}
+
+	return http.DetectContentType(buf[:n]), nil
+}
+
+// staticETag returns a strong ETag for the content hash. Every content coding
+// is a different representation, so it is included in the ETag.
+func staticETag(hash string, encoding string) string {
+	if encoding == "" {
+		return fmt.Sprintf(`"%s"`, hash)
+	}
+
+	return fmt.Sprintf(`"%s-%s"`, hash, encoding)
+}
+
+// staticHandler serves the static files under the URL path prefix. Outside of
+// dev mode dotfiles and source maps are not served, apart from the static path
+// allowedHidden itself, like the .well-known/ directory of the root files.
+// Dotfiles inside of it are still not served.
+func staticHandler(prefix string, allowedHidden string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		serveStatic(w, r, strings.TrimPrefix(r.URL.Path, prefix), allowedHidden)
+	}
+}
+
+func serveStatic(w http.ResponseWriter, r *http.Request, path string, allowedHidden string) {
 	normalizedPath := normalizeStaticPath(path)
 
 	log := zerolog.Ctx(r.Context()).With().
@@ -121,9 +471,24 @@ func staticHandler(w http.ResponseWriter, r *http.Request) {
 		Str("normalizedPath", normalizedPath).
 		Logger()
 
-	file, err := GetStaticFile(normalizedPath)
+	checkedPath := normalizedPath
+	if allowedHidden != "" {
+		if rest, ok := strings.CutPrefix(normalizedPath, allowedHidden); ok {
+			checkedPath = rest
+		}
+	}
+
+	config := getStaticConfig(r.Context())
+	if !config.dev && isHiddenStaticPath(checkedPath) {
+		log.Debug().Msg("refusing to serve hidden static resource")
+		http.NotFound(w, r)
+		return
+	}
+
+	manifest := GetStaticManifest(r.Context())
+	file, err := manifest.Open(path)
 	if err != nil {
-		if errors.Is(err, fs.ErrNotExist) {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
 			http.NotFound(w, r)
 		} else {
 			log.Err(err).Msg("error opening static resource")
@@ -134,42 +499,58 @@ func staticHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer file.Close()
 
-	var buf [512]byte
-	n, err := file.Read(buf[:])
-	if err != nil && err != io.EOF {
+	content, err := asReadSeeker(file)
+	if err != nil {
 		log.Err(err).Msg("error reading static resource")
 		http.Error(w, "internal server error", http.StatusInternalServerError)
 		return
 	}
 
 	if file.PathWithHash == path {
-		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		w.Header().Set("Cache-Control", immutableCacheControl)
 	} else {
 		w.Header().Set("Cache-Control", "public, no-cache")
 	}
 
-	contentType := ""
-	if strings.HasSuffix(path, ".css") {
-		contentType = "text/css"
-	} else if strings.HasSuffix(path, ".js") {
-		contentType = "application/javascript"
-	} else {
-		contentType = http.DetectContentType(buf[:n])
+	contentType, err := config.contentType(path, content)
+	if err != nil {
+		log.Err(err).Msg("error reading static resource")
+		http.Error(w, "internal server error", http.StatusInternalServerError)
+		return
 	}
 
 	if contentType != "" {
 		w.Header().Set("Content-Type", contentType)
 	}
 
-	_, err = w.Write(buf[:n])
-	if err != nil {
-		log.Err(err).Msg("failed to write head of static resource to response writer")
-		return
+	// In dev mode the precompressed variants are likely to be out of date.
+	var (
+		variant  fs.File
+		encoding string
+	)
+	if !manifest.dev {
+		var found bool
+		variant, encoding, found = openPrecompressed(manifest.fsys, file.Path, r.Header.Get("Accept-Encoding"))
+		if found {
+			w.Header().Add("Vary", "Accept-Encoding")
+		}
 	}
 
-	_, err = io.Copy(w, file)
-	if err != nil {
-		log.Err(err).Msg("failed to copy static resource to response writer")
-		return
+	if variant != nil {
+		defer variant.Close()
+
+		content, err = asReadSeeker(variant)
+		if err != nil {
+			log.Err(err).Str("encoding", encoding).Msg("error reading precompressed static resource")
+			http.Error(w, "internal server error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Encoding", encoding)
 	}
+
+	w.Header().Set("ETag", staticETag(file.Hash, encoding))
+
+	// ServeContent takes care of conditional and range requests.
+	http.ServeContent(w, r, file.Path, file.ModTime, content)
 }
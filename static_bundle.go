@@ -0,0 +1,198 @@
+package esox
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// Bundle is a static file made by concatenating other static files, for
+// example Bundle{Path: "app.css", Parts: []string{"reset.css", "layout.css"}}.
+// The bundle is minified unless NoMinify is set and it is served like any
+// other static file. In dev mode the stylesheet and javascript template funcs
+// refer to the parts instead, which makes debugging easier.
+//
+// Relative url() references in bundled stylesheets are rewritten to be
+// relative to the bundle. An @import is only valid at the start of the
+// bundle, so parts other than the first one should not use it. JavaScript
+// bundles are meant for classic scripts, ES modules should use the importmap
+// and module template funcs instead.
+type Bundle struct {
+	Path     string
+	Parts    []string
+	NoMinify bool
+}
+
+// ParseBundle parses a bundle given as path=part1,part2,... as is done by the
+// -bundle flag of the commands in cmd.
+func ParseBundle(value string) (Bundle, error) {
+	bundlePath, parts, ok := strings.Cut(value, "=")
+	if !ok || bundlePath == "" || parts == "" {
+		return Bundle{}, fmt.Errorf("invalid bundle %q, expected path=part1,part2,...", value)
+	}
+
+	return Bundle{Path: bundlePath, Parts: strings.Split(parts, ",")}, nil
+}
+
+// relativeStaticPath returns the path of target relative to the directory
+// dir, both relative to the static root.
+func relativeStaticPath(dir string, target string) string {
+	var from, to []string
+	if dir != "." && dir != "" {
+		from = strings.Split(dir, "/")
+	}
+	to = strings.Split(target, "/")
+
+	common := 0
+	for common < len(from) && common < len(to)-1 && from[common] == to[common] {
+		common++
+	}
+
+	parts := make([]string, 0, len(from)-common+len(to)-common)
+	for range from[common:] {
+		parts = append(parts, "..")
+	}
+	parts = append(parts, to[common:]...)
+
+	return strings.Join(parts, "/")
+}
+
+func (m *StaticManifest) loadBundle(bundle Bundle, visiting map[string]bool) (manifestEntry, error) {
+	visiting[bundle.Path] = true
+	defer delete(visiting, bundle.Path)
+
+	separator := []byte("\n")
+	if isJSModule(bundle.Path) {
+		separator = []byte(";\n")
+	}
+
+	var (
+		content bytes.Buffer
+		modTime time.Time
+		size    int64
+	)
+	deps := make(map[string]string)
+	resolve := func(staticPath string) (string, bool) {
+		dep, err := m.entry(staticPath, visiting)
+		if err != nil {
+			return "", false
+		}
+
+		deps[staticPath] = dep.asset.Hash
+		return dep.asset.PathWithHash, true
+	}
+
+	for i, part := range bundle.Parts {
+		if _, ok := m.bundles[part]; ok {
+			return manifestEntry{}, fmt.Errorf("bundle %s cannot contain the bundle %s", bundle.Path, part)
+		}
+
+		entry, err := m.entry(part, visiting)
+		if err != nil {
+			return manifestEntry{}, fmt.Errorf("bundle %s: %w", bundle.Path, err)
+		}
+		deps[part] = entry.asset.Hash
+
+		raw, err := fs.ReadFile(m.fsys, part)
+		if err != nil {
+			return manifestEntry{}, fmt.Errorf("bundle %s: %w", bundle.Path, err)
+		}
+
+		if strings.HasSuffix(part, ".css") {
+			raw = rewriteCSSRefs(part, bundle.Path, raw, resolve)
+		}
+
+		if i > 0 {
+			content.Write(separator)
+		}
+		content.Write(raw)
+
+		if entry.modTime.After(modTime) {
+			modTime = entry.modTime
+		}
+		size += entry.size
+	}
+
+	out := content.Bytes()
+	if !bundle.NoMinify {
+		if strings.HasSuffix(bundle.Path, ".css") {
+			out = minifyCSS(out)
+		} else if isJSModule(bundle.Path) {
+			out = minifyJS(out)
+		}
+	}
+
+	asset, err := hashStaticContent(bundle.Path, bytes.NewReader(out), m.sri)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	asset.Parts = bundle.Parts
+
+	return manifestEntry{
+		asset:   asset,
+		modTime: modTime,
+		size:    size,
+		content: out,
+		deps:    deps,
+	}, nil
+}
+
+// staticTags formats a tag for the static file with its hashed URL,
+// integrity and crossorigin attribute. In dev mode a tag is formatted for
+// each of the parts of a bundle instead, and scripts are deferred instead of
+// async so the parts run in order. Every file is also added to the preloads
+// as the given destination.
+func staticTags(manifest *StaticManifest, config staticConfig, preloads *preloadLinks, name string, as string, format string) (template.HTML, error) {
+	asset, err := manifest.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	assets := []StaticAsset{asset}
+	expanded := manifest.dev && len(asset.Parts) > 0
+	if expanded {
+		assets = assets[:0]
+		for _, part := range asset.Parts {
+			partAsset, err := manifest.Get(part)
+			if err != nil {
+				return "", err
+			}
+
+			assets = append(assets, partAsset)
+		}
+	}
+
+	var b strings.Builder
+	for _, asset := range assets {
+		url := config.url(asset.PathWithHash)
+		preloads.add(preloadLink{
+			url:         url,
+			rel:         "preload",
+			as:          as,
+			integrity:   asset.Integrity,
+			crossOrigin: config.host != "",
+		})
+
+		attrs := config.crossOriginAttr()
+		if config.dev && as == "style" {
+			// Live reload swaps the stylesheet by its logical path.
+			attrs += fmt.Sprintf(` data-esox-static="%s"`, template.HTMLEscapeString(asset.Path))
+		}
+
+		if as == "script" {
+			// The parts of a bundle have to run in order, like the bundle.
+			if expanded {
+				attrs += " defer"
+			} else {
+				attrs += " async"
+			}
+		}
+
+		fmt.Fprintf(&b, format, template.HTMLEscapeString(url), asset.Integrity, attrs)
+	}
+
+	return template.HTML(b.String()), nil
+}
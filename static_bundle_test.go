@@ -0,0 +1,142 @@
+package esox
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMinifyCSS(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"a {\n  color: red;\n  margin: 0 auto;\n}\n", "a{color:red;margin:0 auto}"},
+		{"/* comment */ a , b > c { x: y }", "a,b>c{x:y}"},
+		{"div :first-child { a: b }", "div :first-child{a:b}"},
+		{"a:hover { content: \"  /* kept */  \" }", "a:hover{content:\"  /* kept */  \"}"},
+		{"@media (min-width: 10px) and (max-width: 20px) {\n a { b: c } }", "@media (min-width:10px) and (max-width:20px){a{b:c}}"},
+		{"a { width: calc(100% - 10px) }", "a{width:calc(100% - 10px)}"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			assert.Equal(t, c.out, string(minifyCSS([]byte(c.in))))
+		})
+	}
+}
+
+func TestMinifyJS(t *testing.T) {
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{"function f(a, b) {\n    // comment\n    return a + b;\n}\n", "function f(a,b){\nreturn a + b;\n}"},
+		{"let a = 1\n\n\nlet b = 2", "let a=1\nlet b=2"},
+		{"const s = 'a // not a comment'", "const s='a // not a comment'"},
+		{"const t = `line\n    kept`", "const t=`line\n    kept`"},
+		{"const r = /\\/\\/[/*]/g; /* x */ f()", "const r=/\\/\\/[/*]/g;f()"},
+		{"return /a b/.test(x)", "return /a b/.test(x)"},
+		{"a = b / c / d", "a=b / c / d"},
+		{"a\n/* multi\nline */\nb", "a\nb"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			assert.Equal(t, c.out, string(minifyJS([]byte(c.in))))
+		})
+	}
+}
+
+func TestRelativeStaticPath(t *testing.T) {
+	cases := []struct {
+		dir    string
+		target string
+		out    string
+	}{
+		{".", "img/a.png", "img/a.png"},
+		{"css", "img/a.png", "../img/a.png"},
+		{"css", "css/a.png", "a.png"},
+		{"a/b", "a/c/d.png", "../c/d.png"},
+		{"a", "a.png", "../a.png"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.dir+"/"+c.target, func(t *testing.T) {
+			assert.Equal(t, c.out, relativeStaticPath(c.dir, c.target))
+		})
+	}
+}
+
+func TestStaticManifestBundle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/reset.css":  {Data: []byte("* { margin: 0; }")},
+		"css/layout.css": {Data: []byte("body {\n  background: url(../img/bg.png);\n}\n")},
+		"img/bg.png":     {Data: []byte("png")},
+	}
+	bundle := Bundle{Path: "app.css", Parts: []string{"css/reset.css", "css/layout.css"}}
+
+	manifest, err := BuildStaticManifest(fsys, bundle)
+	require.NoError(t, err)
+
+	bg, err := manifest.Get("img/bg.png")
+	require.NoError(t, err)
+
+	file, err := manifest.Open("app.css")
+	require.NoError(t, err)
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Equal(t, "*{margin:0}body{background:url("+bg.PathWithHash+")}", string(content))
+	assert.Equal(t, bundle.Parts, file.Parts)
+
+	paths, err := manifest.Paths()
+	require.NoError(t, err)
+	assert.Contains(t, paths, "app.css")
+
+	tags, err := staticTags(manifest, staticConfig{}, nil, "app.css", "style", "%s %s%s\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/static/"+file.PathWithHash+" "+file.Integrity+"\n", string(tags))
+
+	// In dev mode the parts are referred to instead of the bundle.
+	dev, err := StaticOptions{Bundles: []Bundle{bundle}}.NewDevManifest(fsys)
+	require.NoError(t, err)
+
+	tags, err = staticTags(dev, staticConfig{}, nil, "app.css", "style", "%s %s%s\n")
+	require.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(tags), "\n"))
+	assert.Contains(t, string(tags), "css/reset.")
+	assert.Contains(t, string(tags), "css/layout.")
+
+	devAsset, err := dev.Get("app.css")
+	require.NoError(t, err)
+	assert.Equal(t, file.StaticAsset, devAsset)
+}
+
+func TestStaticTagsScriptBundle(t *testing.T) {
+	fsys := fstest.MapFS{
+		"js/a.js": {Data: []byte("var a = 1;")},
+		"js/b.js": {Data: []byte("var b = a;")},
+	}
+	bundle := Bundle{Path: "app.js", Parts: []string{"js/a.js", "js/b.js"}}
+
+	manifest, err := BuildStaticManifest(fsys, bundle)
+	require.NoError(t, err)
+
+	tags, err := staticTags(manifest, staticConfig{}, nil, "app.js", "script", "%[3]s\n")
+	require.NoError(t, err)
+	assert.Equal(t, " async\n", string(tags))
+
+	// The parts run in order in dev mode like the bundle does.
+	dev, err := StaticOptions{Bundles: []Bundle{bundle}}.NewDevManifest(fsys)
+	require.NoError(t, err)
+
+	tags, err = staticTags(dev, staticConfig{dev: true}, nil, "app.js", "script", "%[3]s\n")
+	require.NoError(t, err)
+	assert.Equal(t, " defer\n defer\n", string(tags))
+}
@@ -0,0 +1,94 @@
+package esox
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+var (
+	cssURLPattern    = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^'"\s)]*))\s*\)`)
+	cssImportPattern = regexp.MustCompile(`(?i)@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// staticRefPath resolves a reference found in the static file at filePath to
+// a path relative to the static root. Absolute URLs, data URIs and fragment
+// only references are not resolved.
+func staticRefPath(filePath string, ref string) (resolved string, suffix string, ok bool) {
+	if ref == "" || strings.HasPrefix(ref, "/") || strings.HasPrefix(ref, "#") || strings.Contains(ref, ":") {
+		return "", "", false
+	}
+
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		ref, suffix = ref[:i], ref[i:]
+	}
+
+	resolved = path.Join(path.Dir(filePath), ref)
+	if resolved == ".." || strings.HasPrefix(resolved, "../") {
+		return "", "", false
+	}
+
+	return resolved, suffix, true
+}
+
+// rewriteCSSRefs replaces the relative url() and @import references of the
+// stylesheet at cssPath with their hashed paths. The resolve function gets
+// paths relative to the static root and references it cannot resolve are left
+// as they are. If the content is served from outPath instead of cssPath, as is
+// the case for bundles, all relative references are made relative to it.
+func rewriteCSSRefs(cssPath string, outPath string, content []byte, resolve func(staticPath string) (pathWithHash string, ok bool)) []byte {
+	rewrite := func(pattern *regexp.Regexp, content []byte) []byte {
+		var out []byte
+		last := 0
+		for _, match := range pattern.FindAllSubmatchIndex(content, -1) {
+			start, end := -1, -1
+			for i := 2; i < len(match); i += 2 {
+				if match[i] >= 0 {
+					start, end = match[i], match[i+1]
+					break
+				}
+			}
+
+			if start < 0 {
+				continue
+			}
+
+			ref := string(content[start:end])
+			resolved, suffix, ok := staticRefPath(cssPath, strings.TrimSpace(ref))
+			if !ok {
+				continue
+			}
+
+			pathWithHash, ok := resolve(resolved)
+
+			var hashed string
+			if outPath != cssPath {
+				if !ok {
+					pathWithHash = resolved
+				}
+
+				hashed = relativeStaticPath(path.Dir(outPath), pathWithHash) + suffix
+			} else if ok {
+				// Only the file name changes, so the rest of the reference is
+				// kept as it was written.
+				refPath := strings.TrimSuffix(strings.TrimSpace(ref), suffix)
+				hashed = refPath[:len(refPath)-len(path.Base(refPath))] + path.Base(pathWithHash) + suffix
+			} else {
+				continue
+			}
+
+			out = append(out, content[last:start]...)
+			out = append(out, hashed...)
+			last = end
+		}
+
+		if out == nil {
+			return content
+		}
+
+		return append(out, content[last:]...)
+	}
+
+	content = rewrite(cssURLPattern, content)
+	return rewrite(cssImportPattern, content)
+}
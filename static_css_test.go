@@ -0,0 +1,97 @@
+package esox
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewriteCSSRefs(t *testing.T) {
+	resolve := func(staticPath string) (string, bool) {
+		switch staticPath {
+		case "fonts/a.woff2":
+			return "fonts/a.HASH.woff2", true
+		case "css/reset.css":
+			return "css/reset.HASH.css", true
+		case "img/bg.png":
+			return "img/bg.HASH.png", true
+		}
+
+		return "", false
+	}
+
+	cases := []struct {
+		in  string
+		out string
+	}{
+		{`a { b: url(../fonts/a.woff2) }`, `a { b: url(../fonts/a.HASH.woff2) }`},
+		{`a { b: url("../fonts/a.woff2") }`, `a { b: url("../fonts/a.HASH.woff2") }`},
+		{`a { b: url( '../fonts/a.woff2' ) }`, `a { b: url( '../fonts/a.HASH.woff2' ) }`},
+		{`a { b: URL(../fonts/a.woff2?v=1#x) }`, `a { b: URL(../fonts/a.HASH.woff2?v=1#x) }`},
+		{`@import "reset.css";`, `@import "reset.HASH.css";`},
+		{`@import url(./reset.css);`, `@import url(./reset.HASH.css);`},
+		{`a { b: url(/img/bg.png) }`, `a { b: url(/img/bg.png) }`},
+		{`a { b: url(https://example.com/bg.png) }`, `a { b: url(https://example.com/bg.png) }`},
+		{`a { b: url(data:image/png;base64,AAAA) }`, `a { b: url(data:image/png;base64,AAAA) }`},
+		{`a { b: url(#filter) }`, `a { b: url(#filter) }`},
+		{`a { b: url(missing.png) }`, `a { b: url(missing.png) }`},
+		{`a { b: url(../../outside.png) }`, `a { b: url(../../outside.png) }`},
+		{
+			`a { b: url(../img/bg.png), url(../fonts/a.woff2) }`,
+			`a { b: url(../img/bg.HASH.png), url(../fonts/a.HASH.woff2) }`,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.in, func(t *testing.T) {
+			out := rewriteCSSRefs("css/site.css", "css/site.css", []byte(c.in), resolve)
+			assert.Equal(t, c.out, string(out))
+		})
+	}
+}
+
+func TestStaticManifestCSS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"css/site.css":  {Data: []byte(`@import "a.css"; body { background: url(../img/bg.png) }`)},
+		"css/a.css":     {Data: []byte(`a { b: url(../img/other.png) }`)},
+		"img/bg.png":    {Data: []byte("png")},
+		"img/other.png": {Data: []byte("other")},
+	}
+
+	manifest, err := BuildStaticManifest(fsys)
+	require.NoError(t, err)
+
+	site, err := manifest.Get("css/site.css")
+	require.NoError(t, err)
+
+	bg, err := manifest.Get("img/bg.png")
+	require.NoError(t, err)
+
+	file, err := manifest.Open("css/site.css")
+	require.NoError(t, err)
+	defer file.Close()
+
+	content, err := io.ReadAll(file)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "url(../"+bg.PathWithHash+")")
+
+	expected, err := hashStaticContent("css/site.css", bytes.NewReader(content), nil)
+	require.NoError(t, err)
+	assert.Equal(t, expected, site)
+
+	// Changing a referenced file changes the hash of the stylesheet.
+	dev := NewDevStaticManifest(fsys)
+	before, err := dev.Get("css/site.css")
+	require.NoError(t, err)
+	assert.Equal(t, site, before)
+
+	fsys["img/bg.png"] = &fstest.MapFile{Data: []byte("new png"), ModTime: fsys["img/bg.png"].ModTime.Add(1)}
+
+	after, err := dev.Get("css/site.css")
+	require.NoError(t, err)
+	assert.NotEqual(t, before.Hash, after.Hash)
+}
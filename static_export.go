@@ -0,0 +1,117 @@
+package esox
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+// ExportedStaticFile describes a static file written by StaticManifest.Export.
+type ExportedStaticFile struct {
+	// Path is the hashed path of the file relative to the export directory.
+	Path         string `json:"path"`
+	ContentType  string `json:"contentType"`
+	CacheControl string `json:"cacheControl"`
+	Integrity    string `json:"integrity"`
+}
+
+// Export writes every static file and bundle to dir under its hashed path,
+// ready to be synced to the static mount path of the AssetHost, for example
+// with "aws s3 sync dir s3://bucket/static/". The returned metadata has the
+// Content-Type and Cache-Control the files should be uploaded with. Every
+// resized variant of the images is exported as well. Dotfiles
+// and source maps are not exported, as they are not served in production.
+func (m *StaticManifest) Export(dir string) ([]ExportedStaticFile, error) {
+	paths, err := m.Paths()
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]ExportedStaticFile, 0, len(paths))
+	for _, p := range paths {
+		if isHiddenStaticPath(p) {
+			continue
+		}
+
+		if _, _, ok := m.generatedImage(p); ok {
+			// Exported together with the source image below.
+			continue
+		}
+
+		exported, err := m.exportFile(dir, p)
+		if err != nil {
+			return nil, err
+		}
+
+		out = append(out, exported)
+
+		asset, err := m.Get(p)
+		if err != nil {
+			return nil, err
+		}
+
+		if !isResizableImage(p) {
+			continue
+		}
+
+		for _, variant := range m.imageVariantPaths(asset) {
+			exported, err := m.exportFile(dir, variant)
+			if err != nil {
+				return nil, err
+			}
+
+			out = append(out, exported)
+		}
+	}
+
+	return out, nil
+}
+
+func (m *StaticManifest) exportFile(dir string, staticPath string) (ExportedStaticFile, error) {
+	file, err := m.Open(staticPath)
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+	defer file.Close()
+
+	content, err := asReadSeeker(file)
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+
+	contentType, err := staticConfig{mimeTypes: m.mimeTypes}.contentType(file.Path, content)
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(file.PathWithHash))
+	err = os.MkdirAll(filepath.Dir(target), 0o755)
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+
+	out, err := os.Create(target)
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, content)
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return ExportedStaticFile{}, err
+	}
+
+	return ExportedStaticFile{
+		Path:         file.PathWithHash,
+		ContentType:  contentType,
+		CacheControl: immutableCacheControl,
+		Integrity:    file.Integrity,
+	}, nil
+}
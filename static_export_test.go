@@ -0,0 +1,67 @@
+package esox
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStaticManifestExport(t *testing.T) {
+	manifest, err := StaticOptions{MIMETypes: map[string]string{".glb": "model/gltf-binary"}}.BuildManifest(fstest.MapFS{
+		"css/site.css": {Data: []byte("body { background: url(../img/bg.png) }")},
+		"img/bg.png":   {Data: []byte("\x89PNG\r\n\x1a\n")},
+	})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	exported, err := manifest.Export(dir)
+	require.NoError(t, err)
+	require.Len(t, exported, 2)
+
+	site, err := manifest.Get("css/site.css")
+	require.NoError(t, err)
+
+	assert.Equal(t, ExportedStaticFile{
+		Path:         site.PathWithHash,
+		ContentType:  "text/css; charset=utf-8",
+		CacheControl: immutableCacheControl,
+		Integrity:    site.Integrity,
+	}, exported[0])
+	assert.Equal(t, "image/png", exported[1].ContentType)
+
+	content, err := os.ReadFile(filepath.Join(dir, site.PathWithHash))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), filepath.Base(exported[1].Path))
+
+	manifest, err = BuildStaticManifest(fstest.MapFS{
+		"model.glb": {Data: []byte("glTF")},
+	})
+	require.NoError(t, err)
+
+	exported, err = manifest.Export(t.TempDir())
+	require.NoError(t, err)
+	require.Len(t, exported, 1)
+	assert.Equal(t, "model/gltf-binary", exported[0].ContentType)
+}
+
+func TestStaticTagsAssetHost(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"site.css": {Data: []byte("body {}")},
+	})
+	require.NoError(t, err)
+
+	site, err := manifest.Get("site.css")
+	require.NoError(t, err)
+
+	config := staticConfig{host: "https://cdn.example.com"}
+	tags, err := staticTags(manifest, config, nil, "site.css", "style", `<link href="%s" integrity="%s"%s>`)
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<link href="https://cdn.example.com/static/`+site.PathWithHash+`" integrity="`+site.Integrity+`" crossorigin="anonymous">`,
+		string(tags),
+	)
+}
@@ -0,0 +1,287 @@
+package esox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultImageWidths are the widths resized image variants can be generated
+// in when no widths are configured.
+var DefaultImageWidths = []int{320, 640, 960, 1280, 1920}
+
+const imageJPEGQuality = 85
+
+var imageVariantPattern = regexp.MustCompile(`^(.+)-(\d+)w(\.(?i:jpe?g|png))$`)
+
+// isResizableImage reports whether resized variants can be generated of the
+// static file.
+func isResizableImage(staticPath string) bool {
+	switch strings.ToLower(path.Ext(staticPath)) {
+	case ".jpg", ".jpeg", ".png":
+		return true
+	}
+
+	return false
+}
+
+// imageVariantPath returns the logical path of the variant of the image
+// resized to the width, for example img/hero-320w.jpg for img/hero.jpg.
+func imageVariantPath(source string, width int) string {
+	ext := path.Ext(source)
+	return fmt.Sprintf("%s-%dw%s", strings.TrimSuffix(source, ext), width, ext)
+}
+
+// imageVariant parses the path of a resized image variant. Only the allowed
+// widths are recognized, so that arbitrary variants cannot be requested.
+func (m *StaticManifest) imageVariant(name string) (source string, width int, ok bool) {
+	match := imageVariantPattern.FindStringSubmatch(name)
+	if match == nil {
+		return "", 0, false
+	}
+
+	width, err := strconv.Atoi(match[2])
+	if err != nil || !m.imageWidths[width] {
+		return "", 0, false
+	}
+
+	return match[1] + match[3], width, true
+}
+
+// generatedImage is like imageVariant, but only reports variants which are
+// generated rather than read from a file of the same name.
+func (m *StaticManifest) generatedImage(name string) (source string, width int, ok bool) {
+	source, width, ok = m.imageVariant(name)
+	if !ok {
+		return "", 0, false
+	}
+
+	if _, err := fs.Stat(m.fsys, name); !errors.Is(err, fs.ErrNotExist) {
+		return "", 0, false
+	}
+
+	return source, width, true
+}
+
+func imageSize(fsys fs.FS, staticPath string) (width int, height int, err error) {
+	file, err := fsys.Open(staticPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode image %s: %w", staticPath, err)
+	}
+
+	return config.Width, config.Height, nil
+}
+
+// resizeImage scales the image down to the width, keeping the aspect ratio,
+// by averaging the source pixels covered by each of the resized pixels.
+func resizeImage(src image.Image, width int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba, ok := src.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+	}
+
+	srcWidth, srcHeight := bounds.Dx(), bounds.Dy()
+	height := max(1, (srcHeight*width+srcWidth/2)/srcWidth)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := y * srcHeight / height
+		y1 := max(y0+1, (y+1)*srcHeight/height)
+
+		for x := 0; x < width; x++ {
+			x0 := x * srcWidth / width
+			x1 := max(x0+1, (x+1)*srcWidth/width)
+
+			var r, g, b, a, n int
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += int(p[0])
+					g += int(p[1])
+					b += int(p[2])
+					a += int(p[3])
+					n++
+				}
+			}
+
+			p := dst.Pix[y*dst.Stride+x*4 : y*dst.Stride+x*4+4]
+			p[0] = uint8(r / n)
+			p[1] = uint8(g / n)
+			p[2] = uint8(b / n)
+			p[3] = uint8(a / n)
+		}
+	}
+
+	return dst
+}
+
+func encodeImage(staticPath string, img image.Image) ([]byte, error) {
+	var buf bytes.Buffer
+
+	var err error
+	if strings.ToLower(path.Ext(staticPath)) == ".png" {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: imageJPEGQuality})
+	}
+
+	return buf.Bytes(), err
+}
+
+// loadImageVariant resizes the source image, or reads the resized image from
+// the image cache directory if it has been resized before.
+func (m *StaticManifest) loadImageVariant(name string, source string, width int, visiting map[string]bool) (manifestEntry, error) {
+	sourceEntry, err := m.entry(source, visiting)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	if sourceEntry.asset.Width <= width {
+		return manifestEntry{}, fmt.Errorf("image %s is not wider than %d pixels: %w", source, width, fs.ErrNotExist)
+	}
+
+	var cachePath string
+	if m.imageCacheDir != "" {
+		cachePath = filepath.Join(m.imageCacheDir, fmt.Sprintf("%s-%dw%s", sourceEntry.asset.Hash, width, path.Ext(source)))
+	}
+
+	var content []byte
+	if cachePath != "" {
+		content, _ = os.ReadFile(cachePath)
+	}
+
+	if content == nil {
+		file, err := m.fsys.Open(source)
+		if err != nil {
+			return manifestEntry{}, err
+		}
+		defer file.Close()
+
+		img, _, err := image.Decode(file)
+		if err != nil {
+			return manifestEntry{}, fmt.Errorf("failed to decode image %s: %w", source, err)
+		}
+
+		content, err = encodeImage(name, resizeImage(img, width))
+		if err != nil {
+			return manifestEntry{}, fmt.Errorf("failed to encode image %s: %w", name, err)
+		}
+
+		if cachePath != "" {
+			err := os.MkdirAll(m.imageCacheDir, 0o755)
+			if err == nil {
+				err = os.WriteFile(cachePath, content, 0o644)
+			}
+
+			if err != nil {
+				return manifestEntry{}, fmt.Errorf("failed to cache image %s: %w", name, err)
+			}
+		}
+	}
+
+	asset, err := hashStaticContent(name, bytes.NewReader(content), m.sri)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(content))
+	if err != nil {
+		return manifestEntry{}, err
+	}
+	asset.Width, asset.Height = config.Width, config.Height
+
+	return manifestEntry{
+		asset:   asset,
+		modTime: sourceEntry.modTime,
+		size:    int64(len(content)),
+		content: content,
+		deps:    map[string]string{source: sourceEntry.asset.Hash},
+	}, nil
+}
+
+// imageVariantPaths returns the paths of all of the variants which can be
+// generated of the image.
+func (m *StaticManifest) imageVariantPaths(asset StaticAsset) []string {
+	var out []string
+	for width := range m.imageWidths {
+		if width < asset.Width {
+			out = append(out, imageVariantPath(asset.Path, width))
+		}
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// staticImage returns an img element with a srcset of the image resized to
+// each of the widths narrower than the image itself. The intrinsic size of
+// the image is set to avoid layout shifts.
+func staticImage(manifest *StaticManifest, config staticConfig, name string, alt string, sizes string, widths []int) (template.HTML, error) {
+	asset, err := manifest.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	if !isResizableImage(asset.Path) || asset.Width == 0 {
+		return "", fmt.Errorf("static file %s is not a JPEG or PNG image", asset.Path)
+	}
+
+	sort.Ints(widths)
+
+	var srcset []string
+	for _, width := range widths {
+		if !manifest.imageWidths[width] {
+			return "", fmt.Errorf("image width %d is not one of the allowed image widths", width)
+		}
+
+		if width >= asset.Width {
+			continue
+		}
+
+		variant, err := manifest.Get(imageVariantPath(asset.Path, width))
+		if err != nil {
+			return "", err
+		}
+
+		srcset = append(srcset, fmt.Sprintf("%s %dw", config.url(variant.PathWithHash), width))
+	}
+	srcset = append(srcset, fmt.Sprintf("%s %dw", config.url(asset.PathWithHash), asset.Width))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<img src="%s" srcset="%s"`,
+		template.HTMLEscapeString(config.url(asset.PathWithHash)),
+		template.HTMLEscapeString(strings.Join(srcset, ", ")),
+	)
+
+	if sizes != "" {
+		fmt.Fprintf(&b, ` sizes="%s"`, template.HTMLEscapeString(sizes))
+	}
+
+	fmt.Fprintf(&b, ` width="%d" height="%d" alt="%s" loading="lazy" decoding="async">`,
+		asset.Width, asset.Height, template.HTMLEscapeString(alt),
+	)
+
+	return template.HTML(b.String()), nil
+}
@@ -0,0 +1,115 @@
+package esox
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x), G: uint8(y), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+
+	dst := resizeImage(src, 2)
+	assert.Equal(t, image.Rect(0, 0, 2, 1), dst.Bounds())
+	assert.Equal(t, color.RGBA{200, 200, 200, 200}, dst.RGBAAt(1, 0))
+}
+
+func TestStaticImageVariants(t *testing.T) {
+	cacheDir := t.TempDir()
+	manifest, err := StaticOptions{ImageWidths: []int{20, 40, 200}, ImageCacheDir: cacheDir}.NewDevManifest(fstest.MapFS{
+		"img/hero.png": {Data: testPNG(t, 100, 50)},
+	})
+	require.NoError(t, err)
+
+	hero, err := manifest.Get("img/hero.png")
+	require.NoError(t, err)
+	assert.Equal(t, 100, hero.Width)
+	assert.Equal(t, 50, hero.Height)
+
+	file, err := manifest.Open("img/hero-40w.png")
+	require.NoError(t, err)
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	require.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 40, 20), img.Bounds())
+	assert.Equal(t, 40, file.Width)
+	assert.Equal(t, 20, file.Height)
+
+	cached, err := os.ReadDir(cacheDir)
+	require.NoError(t, err)
+	require.Len(t, cached, 1)
+	assert.Equal(t, hero.Hash+"-40w.png", filepath.Base(cached[0].Name()))
+
+	hashed, err := manifest.Get(file.PathWithHash)
+	require.NoError(t, err)
+	assert.Equal(t, file.StaticAsset, hashed)
+
+	_, err = manifest.Get("img/hero-30w.png")
+	assert.ErrorIs(t, err, os.ErrNotExist, "width is not allowed")
+	_, err = manifest.Get("img/hero-200w.png")
+	assert.ErrorIs(t, err, os.ErrNotExist, "width is not narrower than the image")
+}
+
+func TestStaticImage(t *testing.T) {
+	manifest, err := StaticOptions{ImageWidths: []int{20, 40, 200}}.NewDevManifest(fstest.MapFS{
+		"img/hero.png": {Data: testPNG(t, 100, 50)},
+	})
+	require.NoError(t, err)
+
+	hero, err := manifest.Get("img/hero.png")
+	require.NoError(t, err)
+	small, err := manifest.Get("img/hero-20w.png")
+	require.NoError(t, err)
+
+	config := staticConfig{mount: DefaultStaticMount}
+	html, err := staticImage(manifest, config, "img/hero.png", "A hero", "50vw", []int{200, 20})
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<img src="/static/`+hero.PathWithHash+`" srcset="/static/`+small.PathWithHash+` 20w, /static/`+hero.PathWithHash+` 100w"`+
+			` sizes="50vw" width="100" height="50" alt="A hero" loading="lazy" decoding="async">`,
+		string(html),
+	)
+
+	_, err = staticImage(manifest, config, "img/hero.png", "", "", []int{30})
+	assert.Error(t, err)
+}
+
+func TestStaticManifestExportImages(t *testing.T) {
+	manifest, err := StaticOptions{ImageWidths: []int{20, 40}}.BuildManifest(fstest.MapFS{
+		"img/hero.png": {Data: testPNG(t, 100, 50)},
+	})
+	require.NoError(t, err)
+
+	exported, err := manifest.Export(t.TempDir())
+	require.NoError(t, err)
+	require.Len(t, exported, 3)
+
+	for _, file := range exported {
+		assert.Equal(t, "image/png", file.ContentType)
+	}
+}
@@ -0,0 +1,527 @@
+package esox
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// staticTransformFunc transforms the content of a static file before it is
+// hashed and served. References to other static files are resolved to their
+// hashed paths with resolve.
+type staticTransformFunc func(staticPath string, content []byte, resolve func(staticPath string) (string, bool)) ([]byte, error)
+
+func staticTransform(staticPath string) staticTransformFunc {
+	if strings.HasSuffix(staticPath, ".css") {
+		return func(staticPath string, content []byte, resolve func(string) (string, bool)) ([]byte, error) {
+			return rewriteCSSRefs(staticPath, staticPath, content, resolve), nil
+		}
+	}
+
+	return nil
+}
+
+type manifestEntry struct {
+	asset   StaticAsset
+	modTime time.Time
+	size    int64
+
+	// content is the transformed content of the file, it is nil when the file
+	// is served as is.
+	content []byte
+	// deps maps the static files referred to by the transformed content to
+	// the hashes they had when the content was transformed.
+	deps map[string]string
+}
+
+// StaticManifest maps logical static paths to their hashed paths and
+// integrity. Outside of dev mode it is built once, either by hashing every
+// static file at startup or by loading a JSON file written with WriteJSON, for
+// example by cmd/esox-manifest. In dev mode the entries are computed lazily and
+// recomputed whenever the modification time or size of the file, or of a file
+// it refers to, changes.
+//
+// Stylesheets are transformed before hashing: relative url() and @import
+// references are rewritten to the hashed paths of the files they refer to, so
+// the integrity of a stylesheet covers the files it uses.
+type StaticManifest struct {
+	fsys    fs.FS
+	dev     bool
+	bundles map[string]Bundle
+	sri     []SRIAlgorithm
+
+	imageWidths   map[int]bool
+	imageCacheDir string
+	mimeTypes     map[string]string
+
+	mu      sync.RWMutex
+	entries map[string]manifestEntry
+}
+
+// StaticOptions configure how a StaticManifest is built.
+type StaticOptions struct {
+	// Bundles are built in addition to the static files.
+	Bundles []Bundle
+	// SRIAlgorithms are the hash algorithms listed in the integrity of the
+	// static files, DefaultSRIAlgorithms when empty.
+	SRIAlgorithms []SRIAlgorithm
+	// ImageWidths are the widths resized variants of JPEG and PNG images can
+	// be generated in, DefaultImageWidths when empty.
+	ImageWidths []int
+	// ImageCacheDir is the directory resized images are cached in, they are
+	// only kept in memory when empty.
+	ImageCacheDir string
+	// MIMETypes override the content types of the exported static files by
+	// extension, like App.MIMETypes does for the served ones.
+	MIMETypes map[string]string
+}
+
+func newStaticManifest(fsys fs.FS, opts StaticOptions, dev bool) (*StaticManifest, error) {
+	for _, alg := range opts.SRIAlgorithms {
+		if _, err := alg.newHash(); err != nil {
+			return nil, err
+		}
+	}
+
+	imageWidths := opts.ImageWidths
+	if len(imageWidths) == 0 {
+		imageWidths = DefaultImageWidths
+	}
+
+	widths := make(map[int]bool, len(imageWidths))
+	for _, width := range imageWidths {
+		if width <= 0 {
+			return nil, fmt.Errorf("invalid image width: %d", width)
+		}
+
+		widths[width] = true
+	}
+
+	return &StaticManifest{
+		fsys:          fsys,
+		dev:           dev,
+		bundles:       bundleMap(opts.Bundles),
+		sri:           opts.SRIAlgorithms,
+		imageWidths:   widths,
+		imageCacheDir: opts.ImageCacheDir,
+		mimeTypes:     opts.MIMETypes,
+		entries:       make(map[string]manifestEntry),
+	}, nil
+}
+
+// NewDevStaticManifest returns a manifest which hashes files lazily on first
+// use and rehashes them when they change. Use StaticOptions.NewDevManifest
+// for the other options.
+func NewDevStaticManifest(fsys fs.FS, bundles ...Bundle) *StaticManifest {
+	// The default options are always valid.
+	m, _ := StaticOptions{Bundles: bundles}.NewDevManifest(fsys)
+	return m
+}
+
+// NewDevManifest is NewDevStaticManifest with the options.
+func (opts StaticOptions) NewDevManifest(fsys fs.FS) (*StaticManifest, error) {
+	return newStaticManifest(fsys, opts, true)
+}
+
+func bundleMap(bundles []Bundle) map[string]Bundle {
+	out := make(map[string]Bundle, len(bundles))
+	for _, bundle := range bundles {
+		out[bundle.Path] = bundle
+	}
+
+	return out
+}
+
+// isPrecompressedVariant reports whether the file is a precompressed variant
+// of another static file, those are never looked up by their own name.
+func isPrecompressedVariant(fsys fs.FS, staticPath string) bool {
+	for _, enc := range staticEncodings {
+		original, ok := strings.CutSuffix(staticPath, enc.ext)
+		if !ok {
+			continue
+		}
+
+		if info, err := fs.Stat(fsys, original); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walkStaticFiles calls fn for every static file in fsys, skipping the
+// precompressed variants. A missing root directory has no files.
+func walkStaticFiles(fsys fs.FS, fn func(staticPath string) error) error {
+	return fs.WalkDir(fsys, ".", func(staticPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if staticPath == "." && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+
+			return err
+		}
+
+		if d.IsDir() || isPrecompressedVariant(fsys, staticPath) {
+			return nil
+		}
+
+		return fn(staticPath)
+	})
+}
+
+// BuildStaticManifest hashes every file in fsys and builds the bundles. A
+// missing root directory results in a manifest with only the bundles. Use
+// StaticOptions.BuildManifest for the other options.
+func BuildStaticManifest(fsys fs.FS, bundles ...Bundle) (*StaticManifest, error) {
+	return StaticOptions{Bundles: bundles}.BuildManifest(fsys)
+}
+
+// BuildManifest is BuildStaticManifest with the options.
+func (opts StaticOptions) BuildManifest(fsys fs.FS) (*StaticManifest, error) {
+	// Files are loaded in walk order, but they may refer to files which have
+	// not been walked yet. Building in dev mode loads those on demand.
+	m, err := newStaticManifest(fsys, opts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	err = walkStaticFiles(fsys, func(staticPath string) error {
+		_, err := m.entry(staticPath, make(map[string]bool))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, bundle := range opts.Bundles {
+		_, err := m.entry(bundle.Path, make(map[string]bool))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m.dev = false
+	return m, nil
+}
+
+// ReadStaticManifest loads a manifest written by WriteJSON. The files
+// themselves are still served from fsys and the bundles are built on first
+// use, so they have to be the same the manifest was built with. Use
+// StaticOptions.ReadManifest for the other options.
+func ReadStaticManifest(fsys fs.FS, r io.Reader, bundles ...Bundle) (*StaticManifest, error) {
+	return StaticOptions{Bundles: bundles}.ReadManifest(fsys, r)
+}
+
+// ReadManifest is ReadStaticManifest with the options, which have to be the
+// same the manifest was built with.
+func (opts StaticOptions) ReadManifest(fsys fs.FS, r io.Reader) (*StaticManifest, error) {
+	var assets map[string]StaticAsset
+	err := json.NewDecoder(r).Decode(&assets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode static manifest: %w", err)
+	}
+
+	m, err := newStaticManifest(fsys, opts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	for name, asset := range assets {
+		m.entries[name] = manifestEntry{asset: asset}
+	}
+
+	return m, nil
+}
+
+// WriteJSON writes the manifest as a JSON object keyed by the logical path.
+func (m *StaticManifest) WriteJSON(w io.Writer) error {
+	m.mu.RLock()
+	assets := make(map[string]StaticAsset, len(m.entries))
+	for name, entry := range m.entries {
+		assets[name] = entry.asset
+	}
+	m.mu.RUnlock()
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(assets)
+}
+
+func (m *StaticManifest) depsFresh(entry manifestEntry, visiting map[string]bool) bool {
+	for dep, hash := range entry.deps {
+		current, err := m.entry(dep, visiting)
+		if err != nil || current.asset.Hash != hash {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *StaticManifest) entry(name string, visiting map[string]bool) (manifestEntry, error) {
+	notExist := &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+
+	if visiting[name] {
+		return manifestEntry{}, fmt.Errorf("static file %s refers to itself", name)
+	}
+
+	m.mu.RLock()
+	entry, ok := m.entries[name]
+	m.mu.RUnlock()
+
+	_, _, isImageVariant := m.imageVariant(name)
+
+	if !m.dev {
+		if ok {
+			return entry, nil
+		}
+
+		if !isImageVariant {
+			return manifestEntry{}, notExist
+		}
+
+		// Resized images are only generated when they are first used.
+		return m.loadEntry(name, visiting)
+	}
+
+	if _, isBundle := m.bundles[name]; isBundle {
+		// A bundle has no file of its own, it only depends on its parts.
+		if ok && m.depsFresh(entry, visiting) {
+			return entry, nil
+		}
+	} else {
+		info, err := fs.Stat(m.fsys, name)
+		if isImageVariant && errors.Is(err, fs.ErrNotExist) {
+			// A resized image has no file of its own either, it only
+			// depends on the source image.
+			if ok && m.depsFresh(entry, visiting) {
+				return entry, nil
+			}
+
+			return m.loadEntry(name, visiting)
+		} else if err != nil {
+			return manifestEntry{}, err
+		}
+
+		if info.IsDir() {
+			return manifestEntry{}, notExist
+		}
+
+		if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() && m.depsFresh(entry, visiting) {
+			return entry, nil
+		}
+	}
+
+	return m.loadEntry(name, visiting)
+}
+
+func (m *StaticManifest) loadEntry(name string, visiting map[string]bool) (manifestEntry, error) {
+	entry, err := m.load(name, visiting)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	m.mu.Lock()
+	m.entries[name] = entry
+	m.mu.Unlock()
+
+	return entry, nil
+}
+
+// load hashes the static file, transforming its content first if needed.
+func (m *StaticManifest) load(name string, visiting map[string]bool) (manifestEntry, error) {
+	if bundle, ok := m.bundles[name]; ok {
+		return m.loadBundle(bundle, visiting)
+	}
+
+	if source, width, ok := m.generatedImage(name); ok {
+		return m.loadImageVariant(name, source, width, visiting)
+	}
+
+	info, err := fs.Stat(m.fsys, name)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	transform := staticTransform(name)
+	if transform == nil && isJSModule(name) {
+		content, err := fs.ReadFile(m.fsys, name)
+		if err != nil {
+			return manifestEntry{}, err
+		}
+
+		asset, err := hashStaticContent(name, bytes.NewReader(content), m.sri)
+		if err != nil {
+			return manifestEntry{}, err
+		}
+
+		asset.Imports = jsImports(name, content)
+		return manifestEntry{asset: asset, modTime: info.ModTime(), size: info.Size()}, nil
+	} else if transform == nil {
+		asset, err := hashStaticFile(m.fsys, name, m.sri)
+		if err != nil {
+			return manifestEntry{}, err
+		}
+
+		if isResizableImage(name) {
+			// Images which cannot be decoded are still served, they just
+			// cannot be resized.
+			asset.Width, asset.Height, _ = imageSize(m.fsys, name)
+		}
+
+		return manifestEntry{asset: asset, modTime: info.ModTime(), size: info.Size()}, nil
+	}
+
+	// References back to a file which is being transformed are cyclic and
+	// are left as they are.
+	visiting[name] = true
+	defer delete(visiting, name)
+
+	raw, err := fs.ReadFile(m.fsys, name)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	deps := make(map[string]string)
+	content, err := transform(name, raw, func(staticPath string) (string, bool) {
+		dep, err := m.entry(staticPath, visiting)
+		if err != nil {
+			return "", false
+		}
+
+		deps[staticPath] = dep.asset.Hash
+		return dep.asset.PathWithHash, true
+	})
+	if err != nil {
+		return manifestEntry{}, fmt.Errorf("failed to transform static file %s: %w", name, err)
+	}
+
+	asset, err := hashStaticContent(name, bytes.NewReader(content), m.sri)
+	if err != nil {
+		return manifestEntry{}, err
+	}
+
+	return manifestEntry{
+		asset:   asset,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		content: content,
+		deps:    deps,
+	}, nil
+}
+
+// Paths returns the logical paths of all of the static files, sorted.
+func (m *StaticManifest) Paths() ([]string, error) {
+	if m.dev {
+		var out []string
+		err := walkStaticFiles(m.fsys, func(staticPath string) error {
+			if _, ok := m.bundles[staticPath]; !ok {
+				out = append(out, staticPath)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for name := range m.bundles {
+			out = append(out, name)
+		}
+
+		sort.Strings(out)
+		return out, nil
+	}
+
+	m.mu.RLock()
+	out := make([]string, 0, len(m.entries))
+	for name := range m.entries {
+		out = append(out, name)
+	}
+	m.mu.RUnlock()
+
+	sort.Strings(out)
+	return out, nil
+}
+
+// resolve returns the entry of the static path, which may contain the hash of
+// the file. The hash is only stripped when it is the hash of the file, so a
+// file with a segment in its name which looks like a hash is found by its own
+// name.
+func (m *StaticManifest) resolve(staticPath string) (string, manifestEntry, error) {
+	if name, hash, ok := splitPathHash(staticPath); ok {
+		entry, err := m.entry(name, make(map[string]bool))
+		if err == nil && (entry.asset.Hash == hash || (len(hash) == legacyHashLength && strings.HasPrefix(hash, entry.asset.Hash))) {
+			return name, entry, nil
+		}
+	}
+
+	entry, err := m.entry(staticPath, make(map[string]bool))
+	return staticPath, entry, err
+}
+
+// Get returns the asset for the static path, which may already contain the
+// hash of the file. If the file does not exist an error wrapping
+// fs.ErrNotExist is returned.
+func (m *StaticManifest) Get(staticPath string) (StaticAsset, error) {
+	_, entry, err := m.resolve(staticPath)
+	return entry.asset, err
+}
+
+type readSeekNopCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekNopCloser) Close() error {
+	return nil
+}
+
+// Open returns the asset for the static path together with its content.
+func (m *StaticManifest) Open(staticPath string) (StaticFile, error) {
+	name, entry, err := m.resolve(staticPath)
+	if err != nil {
+		return StaticFile{}, err
+	}
+
+	_, isBundle := m.bundles[name]
+	_, _, isGeneratedImage := m.generatedImage(name)
+	if entry.content == nil && (isBundle || isGeneratedImage || staticTransform(name) != nil) {
+		// The entry was read from a JSON manifest, which only has the hashes.
+		loaded, err := m.load(name, make(map[string]bool))
+		if err != nil {
+			return StaticFile{}, err
+		}
+
+		if loaded.asset.Hash != entry.asset.Hash {
+			return StaticFile{}, fmt.Errorf("static file %s does not match the static manifest", name)
+		}
+
+		m.mu.Lock()
+		m.entries[name] = loaded
+		m.mu.Unlock()
+
+		entry = loaded
+	}
+
+	if entry.content == nil {
+		return openStaticFile(m.fsys, entry.asset)
+	}
+
+	return StaticFile{
+		ReadCloser:  readSeekNopCloser{bytes.NewReader(entry.content)},
+		StaticAsset: entry.asset,
+		ModTime:     entry.modTime,
+	}, nil
+}
+
+// defaultStaticManifest is used when the context has not been set up by an
+// App, it reads the files from StaticPrefix.
+var defaultStaticManifest = NewDevStaticManifest(os.DirFS(StaticPrefix))
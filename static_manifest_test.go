@@ -0,0 +1,114 @@
+package esox
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildStaticManifest(t *testing.T) {
+	fsys := fstest.MapFS{
+		"styles.css":      {Data: []byte("body {}")},
+		"styles.css.gz":   {Data: []byte("compressed")},
+		"folder/app.js":   {Data: []byte("console.log(1)")},
+		"archive.tar.gz":  {Data: []byte("not a variant")},
+		"folder/empty.js": {Data: nil},
+	}
+
+	manifest, err := BuildStaticManifest(fsys)
+	require.NoError(t, err)
+
+	asset, err := manifest.Get("styles.css")
+	require.NoError(t, err)
+	assert.Equal(t, "styles.css", asset.Path)
+
+	hashed, err := manifest.Get(asset.PathWithHash)
+	require.NoError(t, err)
+	assert.Equal(t, asset, hashed)
+
+	_, err = manifest.Get("styles.css.gz")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	_, err = manifest.Get("archive.tar.gz")
+	assert.NoError(t, err)
+
+	_, err = manifest.Get("missing.css")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	var buf bytes.Buffer
+	require.NoError(t, manifest.WriteJSON(&buf))
+
+	loaded, err := ReadStaticManifest(fsys, &buf)
+	require.NoError(t, err)
+
+	for _, name := range []string{"styles.css", "folder/app.js", "folder/empty.js"} {
+		expected, err := manifest.Get(name)
+		require.NoError(t, err)
+
+		actual, err := loaded.Get(name)
+		require.NoError(t, err)
+		assert.Equal(t, expected, actual)
+	}
+}
+
+func TestStaticManifestHashedPaths(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"styles.css":                 {Data: []byte("body {}")},
+		"data.0123456789abcdef.json": {Data: []byte("{}")},
+	})
+	require.NoError(t, err)
+
+	asset, err := manifest.Get("styles.css")
+	require.NoError(t, err)
+
+	// A segment which only looks like a hash is part of the file name.
+	data, err := manifest.Get("data.0123456789abcdef.json")
+	require.NoError(t, err)
+	assert.Equal(t, "data.0123456789abcdef.json", data.Path)
+
+	_, err = manifest.Get("styles.0123456789abcdef.css")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+
+	legacy := sha256.Sum256([]byte("body {}"))
+	legacyAsset, err := manifest.Get("styles." + hex.EncodeToString(legacy[:]) + ".css")
+	require.NoError(t, err)
+	assert.Equal(t, asset, legacyAsset)
+}
+
+func TestBuildStaticManifestMissingRoot(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{})
+	require.NoError(t, err)
+
+	_, err = manifest.Get("styles.css")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestDevStaticManifestInvalidation(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"styles.css": {Data: []byte("body {}"), ModTime: modTime},
+	}
+
+	manifest := NewDevStaticManifest(fsys)
+
+	before, err := manifest.Get("styles.css")
+	require.NoError(t, err)
+
+	fsys["styles.css"] = &fstest.MapFile{Data: []byte("body { color: red }"), ModTime: modTime.Add(time.Second)}
+
+	after, err := manifest.Get("styles.css")
+	require.NoError(t, err)
+	assert.NotEqual(t, before.PathWithHash, after.PathWithHash)
+
+	delete(fsys, "styles.css")
+
+	_, err = manifest.Get("styles.css")
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
@@ -0,0 +1,227 @@
+package esox
+
+import (
+	"bytes"
+	"strings"
+)
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// stringEnd returns the index just after the string literal starting at i.
+// Unterminated strings end at the end of the source.
+func stringEnd(src []byte, i int) int {
+	quote := src[i]
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case quote:
+			return j + 1
+		}
+	}
+
+	return len(src)
+}
+
+// minifyCSS removes comments and unnecessary whitespace from a stylesheet.
+// Strings are copied as they are.
+func minifyCSS(src []byte) []byte {
+	const tight = "{};,>:"
+
+	out := make([]byte, 0, len(src))
+	pendingSpace := false
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		if c == '/' && i+1 < len(src) && src[i+1] == '*' {
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				break
+			}
+
+			i += end + 4
+			pendingSpace = true
+			continue
+		}
+
+		if isSpace(c) {
+			pendingSpace = true
+			i++
+			continue
+		}
+
+		if len(out) > 0 {
+			last := out[len(out)-1]
+
+			// Whitespace before a colon is significant in selectors such as
+			// "a :hover", so only the space after it is removed.
+			if pendingSpace && !strings.ContainsRune(tight, rune(last)) && (c == ':' || !strings.ContainsRune(tight, rune(c))) {
+				out = append(out, ' ')
+			}
+
+			if c == '}' && last == ';' {
+				out = out[:len(out)-1]
+			}
+		}
+		pendingSpace = false
+
+		if c == '"' || c == '\'' {
+			end := stringEnd(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+			continue
+		}
+
+		out = append(out, c)
+		i++
+	}
+
+	return out
+}
+
+var jsRegexpKeywords = []string{
+	"return", "typeof", "instanceof", "case", "do", "else", "in", "new",
+	"delete", "void", "throw", "yield", "await",
+}
+
+// jsRegexpAllowed reports whether a slash following the already written
+// output starts a regular expression literal instead of being a division.
+func jsRegexpAllowed(out []byte) bool {
+	end := len(out)
+	for end > 0 && isSpace(out[end-1]) {
+		end--
+	}
+
+	if end == 0 {
+		return true
+	}
+
+	last := out[end-1]
+	if strings.IndexByte("(,=:[!&|?{};+-*%<>~^", last) >= 0 {
+		return true
+	}
+
+	start := end
+	for start > 0 && isJSIdentByte(out[start-1]) {
+		start--
+	}
+
+	word := string(out[start:end])
+	for _, keyword := range jsRegexpKeywords {
+		if word == keyword {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isJSIdentByte(c byte) bool {
+	return c == '_' || c == '$' || c >= 0x80 ||
+		('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z') || ('0' <= c && c <= '9')
+}
+
+// regexpEnd returns the index just after the regular expression literal
+// starting at i, not including its flags.
+func regexpEnd(src []byte, i int) int {
+	inClass := false
+	for j := i + 1; j < len(src); j++ {
+		switch src[j] {
+		case '\\':
+			j++
+		case '[':
+			inClass = true
+		case ']':
+			inClass = false
+		case '/':
+			if !inClass {
+				return j + 1
+			}
+		case '\n':
+			return j
+		}
+	}
+
+	return len(src)
+}
+
+// minifyJS removes comments, indentation and blank lines from a script. Line
+// breaks are kept so that automatic semicolon insertion keeps working, which
+// makes this safe for any script but far from optimal.
+func minifyJS(src []byte) []byte {
+	const tight = "{}()[];,:="
+
+	out := make([]byte, 0, len(src))
+	for i := 0; i < len(src); {
+		c := src[i]
+
+		switch {
+		case c == '"' || c == '\'' || c == '`':
+			end := stringEnd(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			end := bytes.IndexByte(src[i:], '\n')
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end
+			}
+
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(src)
+				break
+			}
+
+			comment := src[i : i+end+4]
+			i += end + 4
+
+			// A comment acts as whitespace, a multiline one as a line break.
+			if bytes.IndexByte(comment, '\n') >= 0 {
+				out = append(out, '\n')
+			} else {
+				out = append(out, ' ')
+			}
+
+		case c == '/' && jsRegexpAllowed(out):
+			end := regexpEnd(src, i)
+			out = append(out, src[i:end]...)
+			i = end
+
+		case isSpace(c):
+			start := i
+			for i < len(src) && isSpace(src[i]) {
+				i++
+			}
+
+			// Drop the whitespace which was already written, comments are
+			// replaced with whitespace which is collapsed here.
+			newline := bytes.IndexByte(src[start:i], '\n') >= 0
+			for len(out) > 0 && isSpace(out[len(out)-1]) {
+				newline = newline || out[len(out)-1] == '\n'
+				out = out[:len(out)-1]
+			}
+
+			if len(out) == 0 || i == len(src) {
+				break
+			}
+
+			if newline {
+				out = append(out, '\n')
+			} else if strings.IndexByte(tight, out[len(out)-1]) < 0 && strings.IndexByte(tight, src[i]) < 0 {
+				out = append(out, ' ')
+			}
+
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+
+	return bytes.TrimSpace(out)
+}
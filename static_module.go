@@ -0,0 +1,254 @@
+package esox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var jsImportPattern = regexp.MustCompile(`(?m)(?:^|[;\s}])(?:import|export)\s*(?:[\w$*{}\s,]+?\s*from\s*)?["']([^"'\n]+)["']`)
+
+func isJSModule(staticPath string) bool {
+	ext := path.Ext(staticPath)
+	return ext == ".js" || ext == ".mjs"
+}
+
+// jsImports returns the static files statically imported by the JavaScript
+// module. Relative imports are resolved to static paths, while absolute paths
+// are kept as they are since the mount of the static files is only known when
+// rendering. Bare specifiers, URLs and dynamic imports are ignored.
+func jsImports(modulePath string, content []byte) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, match := range jsImportPattern.FindAllSubmatch(content, -1) {
+		specifier := string(match[1])
+
+		var resolved string
+		if strings.HasPrefix(specifier, "/") && !strings.HasPrefix(specifier, "//") {
+			if i := strings.IndexAny(specifier, "?#"); i >= 0 {
+				specifier = specifier[:i]
+			}
+
+			resolved = path.Clean(specifier)
+		} else if strings.HasPrefix(specifier, "./") || strings.HasPrefix(specifier, "../") {
+			var ok bool
+			resolved, _, ok = staticRefPath(modulePath, specifier)
+			if !ok {
+				continue
+			}
+		} else {
+			continue
+		}
+
+		if !seen[resolved] {
+			seen[resolved] = true
+			out = append(out, resolved)
+		}
+	}
+
+	return out
+}
+
+type importMap struct {
+	Imports   map[string]string `json:"imports"`
+	Integrity map[string]string `json:"integrity,omitempty"`
+}
+
+// staticImportMap returns an import map which maps the unhashed URLs of the
+// JavaScript modules to their hashed URLs. Relative and absolute imports
+// between the modules therefore load the hashed files. If no paths are given
+// all of the JavaScript modules in the manifest are included.
+func staticImportMap(manifest *StaticManifest, config staticConfig, modules *staticModules, paths []string) (template.HTML, error) {
+	if len(paths) == 0 {
+		all, err := manifest.Paths()
+		if err != nil {
+			return "", err
+		}
+
+		for _, p := range all {
+			if isJSModule(p) {
+				paths = append(paths, p)
+			}
+		}
+	}
+
+	out := importMap{
+		Imports:   make(map[string]string, len(paths)),
+		Integrity: make(map[string]string, len(paths)),
+	}
+	for _, p := range paths {
+		asset, err := manifest.Get(p)
+		if err != nil {
+			return "", err
+		}
+
+		hashedURL := config.url(asset.PathWithHash)
+		out.Imports[config.url(asset.Path)] = hashedURL
+		out.Integrity[hashedURL] = asset.Integrity
+	}
+
+	// json.Marshal escapes <, > and &, so the result is safe inside a script.
+	content, err := json.Marshal(out)
+	if err != nil {
+		return "", err
+	}
+
+	return modules.importMapTag(content), nil
+}
+
+// moduleDeps returns the transitive static imports of the module, in the
+// order they were found. Absolute imports outside of the mount are skipped.
+func moduleDeps(manifest *StaticManifest, mount string, asset StaticAsset) ([]StaticAsset, error) {
+	var out []StaticAsset
+	seen := map[string]bool{asset.Path: true}
+	queue := asset.Imports
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+
+		if strings.HasPrefix(p, "/") {
+			var ok bool
+			p, ok = strings.CutPrefix(p, mount)
+			if !ok {
+				continue
+			}
+		}
+
+		if seen[p] {
+			continue
+		}
+		seen[p] = true
+
+		dep, err := manifest.Get(p)
+		if err != nil {
+			return nil, fmt.Errorf("module %s imports %s: %w", asset.Path, p, err)
+		}
+
+		out = append(out, dep)
+		queue = append(queue, dep.Imports...)
+	}
+
+	return out, nil
+}
+
+// staticModules tracks whether the import map of the page has been written.
+// It has to come before the first module script, and a page may only have one.
+type staticModules struct {
+	importMap bool
+
+	// nonce is the CSP nonce of the request the import map is allowed with.
+	// Without one, the hashes of the import maps written are collected and
+	// added to the CSP of the response by writeCSP.
+	nonce  string
+	hashes []string
+}
+
+type staticModulesKey struct{}
+
+func getStaticModules(ctx context.Context) *staticModules {
+	value, _ := ctx.Value(staticModulesKey{}).(*staticModules)
+	return value
+}
+
+// writeImportMap marks the import map of the page as written, failing if it has
+// been written already.
+func (m *staticModules) writeImportMap() error {
+	if m == nil {
+		return nil
+	}
+
+	if m.importMap {
+		return errors.New("importmap must be used once, before the first module")
+	}
+
+	m.importMap = true
+	return nil
+}
+
+// importMapTag returns the inline script of the import map, with the nonce of
+// the request or else with its hash recorded for writeCSP.
+func (m *staticModules) importMapTag(content []byte) template.HTML {
+	if m != nil && m.nonce != "" {
+		return template.HTML(fmt.Sprintf(`<script type="importmap" nonce="%s">%s</script>`, template.HTMLEscapeString(m.nonce), content))
+	}
+
+	if m != nil {
+		m.hashes = append(m.hashes, cspHash(content))
+	}
+
+	return template.HTML(fmt.Sprintf(`<script type="importmap">%s</script>`, content))
+}
+
+// writeCSP allows the import maps written without a nonce in the CSP of the
+// response. It has to be called before the headers are written, so an import
+// map in the body of a streamed page needs a nonce.
+func (m *staticModules) writeCSP(header http.Header) {
+	if m == nil || len(m.hashes) == 0 {
+		return
+	}
+
+	csp := header.Get("Content-Security-Policy")
+	if csp == "" {
+		return
+	}
+
+	for _, hash := range m.hashes {
+		csp = addCSPSource(csp, "script-src", hash)
+	}
+
+	header.Set("Content-Security-Policy", csp)
+}
+
+// staticModule returns a module script for the JavaScript module, preceded
+// by modulepreload links for all of the modules it imports. The module and
+// its imports are also added to the preloads. Unless the page already has an
+// import map, one with all of the modules is written before the first module,
+// so that the imports between the modules load the preloaded hashed files.
+func staticModule(manifest *StaticManifest, config staticConfig, preloads *preloadLinks, modules *staticModules, name string) (template.HTML, error) {
+	asset, err := manifest.Get(name)
+	if err != nil {
+		return "", err
+	}
+
+	deps, err := moduleDeps(manifest, config.mountPath(), asset)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	if modules != nil && !modules.importMap {
+		importMap, err := staticImportMap(manifest, config, modules, nil)
+		if err != nil {
+			return "", err
+		}
+
+		modules.importMap = true
+		b.WriteString(string(importMap))
+	}
+
+	for _, dep := range deps {
+		url := config.url(dep.PathWithHash)
+		preloads.add(preloadLink{url: url, rel: "modulepreload", integrity: dep.Integrity})
+
+		fmt.Fprintf(&b,
+			`<link rel="modulepreload" href="%s" integrity="%s">`,
+			template.HTMLEscapeString(url), dep.Integrity,
+		)
+	}
+
+	url := config.url(asset.PathWithHash)
+	preloads.add(preloadLink{url: url, rel: "modulepreload", integrity: asset.Integrity})
+
+	fmt.Fprintf(&b,
+		`<script type="module" src="%s" integrity="%s"></script>`,
+		template.HTMLEscapeString(url), asset.Integrity,
+	)
+
+	return template.HTML(b.String()), nil
+}
@@ -0,0 +1,117 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJSImports(t *testing.T) {
+	content := `
+import "./side-effect.js";
+import { a, b } from "./lib/ab.js";
+import * as c from '../shared/c.mjs';
+import d from "/static/js/d.js";
+import e from "lodash";
+import f from "https://example.com/f.js";
+export { g } from "./g.js";
+const h = await import("./h.js");
+import "./lib/ab.js";
+`
+
+	assert.Equal(t, []string{
+		"js/side-effect.js",
+		"js/lib/ab.js",
+		"shared/c.mjs",
+		"/static/js/d.js",
+		"js/g.js",
+	}, jsImports("js/app.js", []byte(content)))
+}
+
+func TestStaticModule(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"js/app.js":  {Data: []byte(`import { b } from "./b.js";`)},
+		"js/b.js":    {Data: []byte(`import "./c.js"; export const b = 1;`)},
+		"js/c.js":    {Data: []byte(`import "./b.js";`)},
+		"styles.css": {Data: []byte(`body {}`)},
+	})
+	require.NoError(t, err)
+
+	app, err := manifest.Get("js/app.js")
+	require.NoError(t, err)
+
+	b, err := manifest.Get("js/b.js")
+	require.NoError(t, err)
+
+	c, err := manifest.Get("js/c.js")
+	require.NoError(t, err)
+
+	out, err := staticModule(manifest, staticConfig{}, nil, nil, "js/app.js")
+	require.NoError(t, err)
+	assert.Equal(t,
+		`<link rel="modulepreload" href="/static/`+b.PathWithHash+`" integrity="`+b.Integrity+`">`+
+			`<link rel="modulepreload" href="/static/`+c.PathWithHash+`" integrity="`+c.Integrity+`">`+
+			`<script type="module" src="/static/`+app.PathWithHash+`" integrity="`+app.Integrity+`"></script>`,
+		string(out),
+	)
+
+	out, err = staticImportMap(manifest, staticConfig{}, nil, nil)
+	require.NoError(t, err)
+	assert.Contains(t, string(out), `"/static/js/b.js":"/static/`+b.PathWithHash+`"`)
+	assert.Contains(t, string(out), `"/static/`+b.PathWithHash+`":"`+b.Integrity+`"`)
+	assert.NotContains(t, string(out), "styles")
+
+	modules := &staticModules{}
+	out, err = staticModule(manifest, staticConfig{}, nil, modules, "js/app.js")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(out), `<script type="importmap">`))
+
+	out, err = staticModule(manifest, staticConfig{}, nil, modules, "js/b.js")
+	require.NoError(t, err)
+	assert.NotContains(t, string(out), "importmap")
+	assert.Error(t, modules.writeImportMap())
+}
+
+func TestStaticModuleCSP(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"app.js": {Data: []byte(`export const a = 1;`)},
+	})
+	require.NoError(t, err)
+
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html":  {Data: []byte(`<head>{{ module "app.js" }}</head>`)},
+		"index.html": {Data: []byte(``)},
+	}, false)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	ctx = context.WithValue(ctx, staticManifestKey{}, manifest)
+	tmpl := &Template{name: "index.html", baseName: "base.html"}
+
+	render := func(ctx context.Context) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Security-Policy", "default-src 'self'")
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		tmpl.Render(w, r, http.StatusOK, &testRenderData{})
+		return w
+	}
+
+	w := render(ctx)
+	start := strings.Index(w.Body.String(), `<script type="importmap">`) + len(`<script type="importmap">`)
+	end := strings.Index(w.Body.String(), `</script>`)
+	require.Less(t, start, end)
+	assert.Equal(t,
+		"default-src 'self' "+cspHash([]byte(w.Body.String()[start:end])),
+		w.Header().Get("Content-Security-Policy"),
+	)
+
+	w = render(context.WithValue(ctx, nonceKey{}, "abc"))
+	assert.Contains(t, w.Body.String(), `<script type="importmap" nonce="abc">`)
+	assert.Equal(t, "default-src 'self'", w.Header().Get("Content-Security-Policy"))
+}
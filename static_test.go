@@ -2,10 +2,16 @@ package esox
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var testHash string
@@ -16,7 +22,7 @@ func init() {
 		err error
 	)
 	buf.WriteString("test")
-	testHash, _, err = integrityHash(&buf)
+	testHash, _, err = integrityHash(&buf, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -32,6 +38,12 @@ func TestNormalizeStaticPath(t *testing.T) {
 		{"plain file", "plain file"},
 		{"styles.css", "styles.css"},
 		{"styles.min.css", "styles.min.css"},
+		{"styles.notahexhash.css", "styles.notahexhash.css"},
+
+		{
+			"styles.9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08.css",
+			"styles.css",
+		},
 
 		{
 			fmt.Sprintf("styles.%s.css", testHash),
@@ -128,3 +140,169 @@ func TestStaticPathWithHashError(t *testing.T) {
 		})
 	}
 }
+
+func TestAcceptsEncoding(t *testing.T) {
+	cases := []struct {
+		header   string
+		encoding string
+		out      bool
+	}{
+		{"", "gzip", false},
+		{"gzip", "gzip", true},
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate", "br", false},
+		{"GZIP", "gzip", true},
+		{"br;q=0, gzip", "br", false},
+		{"br;q=0.5, gzip", "br", true},
+		{"*", "br", true},
+		{"*;q=0", "br", false},
+		{"br;q=0, *", "br", false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.header+"/"+c.encoding, func(t *testing.T) {
+			assert.Equal(t, c.out, acceptsEncoding(c.header, c.encoding))
+		})
+	}
+}
+
+func staticTestRequest(manifest *StaticManifest, target string, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	r = r.WithContext(context.WithValue(r.Context(), staticManifestKey{}, manifest))
+	for key, values := range header {
+		r.Header[key] = values
+	}
+
+	w := httptest.NewRecorder()
+	staticHandler(DefaultStaticMount, "")(w, r)
+	return w
+}
+
+func TestStaticHandlerConditionalAndRange(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"video.mp4":     {Data: []byte("0123456789"), ModTime: modTime},
+		"styles.css":    {Data: []byte("body {}"), ModTime: modTime},
+		"styles.css.gz": {Data: []byte("gzipped"), ModTime: modTime},
+	})
+	require.NoError(t, err)
+
+	w := staticTestRequest(manifest, "/static/video.mp4", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, modTime.Format(http.TimeFormat), w.Header().Get("Last-Modified"))
+
+	w = staticTestRequest(manifest, "/static/video.mp4", http.Header{"If-None-Match": {etag}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = staticTestRequest(manifest, "/static/video.mp4", http.Header{"If-Modified-Since": {modTime.Format(http.TimeFormat)}})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+
+	w = staticTestRequest(manifest, "/static/video.mp4", http.Header{"Range": {"bytes=2-4"}})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "234", w.Body.String())
+
+	w = staticTestRequest(manifest, "/static/styles.css", http.Header{"Accept-Encoding": {"gzip"}})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gzip", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "gzipped", w.Body.String())
+	gzipETag := w.Header().Get("ETag")
+
+	w = staticTestRequest(manifest, "/static/styles.css", nil)
+	assert.Equal(t, "body {}", w.Body.String())
+	assert.NotEqual(t, gzipETag, w.Header().Get("ETag"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+}
+
+func TestStaticHandlerHiddenAndContentType(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		".env":                       {Data: []byte("SECRET=1")},
+		"app.js.map":                 {Data: []byte("{}")},
+		"icon.svg":                   {Data: []byte("<svg></svg>")},
+		"module.wasm":                {Data: []byte("\x00asm")},
+		"model.glb":                  {Data: []byte("glTF")},
+		".well-known/security.txt":   {Data: []byte("Contact: x")},
+		".well-known/.secret":        {Data: []byte("x")},
+		"folder/.hidden/styles.css":  {Data: []byte("body {}")},
+		"folder/not.hidden/main.css": {Data: []byte("body {}")},
+	})
+	require.NoError(t, err)
+
+	serve := func(config staticConfig, handler http.HandlerFunc, target string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		ctx := context.WithValue(r.Context(), staticManifestKey{}, manifest)
+		ctx = context.WithValue(ctx, staticConfigKey{}, config)
+
+		w := httptest.NewRecorder()
+		handler(w, r.WithContext(ctx))
+		return w
+	}
+
+	production := staticConfig{mimeTypes: map[string]string{".glb": "model/gltf-binary"}}
+	dev := staticConfig{dev: true}
+	static := staticHandler(DefaultStaticMount, "")
+
+	cases := []struct {
+		config      staticConfig
+		handler     http.HandlerFunc
+		target      string
+		code        int
+		contentType string
+	}{
+		{production, static, "/static/.env", http.StatusNotFound, ""},
+		{production, static, "/static/app.js.map", http.StatusNotFound, ""},
+		{production, static, "/static/folder/.hidden/styles.css", http.StatusNotFound, ""},
+		{production, static, "/static/folder/not.hidden/main.css", http.StatusOK, "text/css; charset=utf-8"},
+		{production, static, "/static/.well-known/security.txt", http.StatusNotFound, ""},
+		{production, static, "/static/icon.svg", http.StatusOK, "image/svg+xml"},
+		{production, static, "/static/module.wasm", http.StatusOK, "application/wasm"},
+		{production, static, "/static/model.glb", http.StatusOK, "model/gltf-binary"},
+		{dev, static, "/static/app.js.map", http.StatusOK, "application/json"},
+		{production, staticHandler("/", ".well-known/"), "/.well-known/security.txt", http.StatusOK, "text/plain; charset=utf-8"},
+		{production, staticHandler("/", ".well-known/"), "/.well-known/.secret", http.StatusNotFound, ""},
+		{production, staticHandler("/", ".well-known/"), "/.env", http.StatusNotFound, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.target, func(t *testing.T) {
+			w := serve(c.config, c.handler, c.target)
+			assert.Equal(t, c.code, w.Code)
+			if c.contentType != "" {
+				assert.Equal(t, c.contentType, w.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestAppStaticRootFiles(t *testing.T) {
+	manifest, err := BuildStaticManifest(fstest.MapFS{
+		"robots.txt":               {Data: []byte("User-agent: *")},
+		".well-known/security.txt": {Data: []byte("Contact: x")},
+		"styles.css":               {Data: []byte("body {}")},
+	})
+	require.NoError(t, err)
+
+	app := App{}
+	rootFiles, err := app.staticRootFiles(manifest)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"/robots.txt":   "robots.txt",
+		"/.well-known/": ".well-known/",
+	}, rootFiles)
+}
+
+func TestIntegrityHash(t *testing.T) {
+	pathHash, integrity, err := integrityHash(bytes.NewBufferString("test"), []SRIAlgorithm{SHA384, SHA512})
+	require.NoError(t, err)
+
+	assert.Equal(t, "9f86d081884c7d65", pathHash)
+	assert.Equal(t,
+		"sha384-doQSMg97CqWBL85CjcRwazyuUOAqZMqhangiSb/o78S37xzLEmJV0ZYEff7fF6Cp "+
+			"sha512-7iaw3Ur350mqGo7jwQrpkj9hiYB3Lkc/iBml1JQODbJ6wYX4oOHV+E+IvIh/1nsUNzLDBMxfqa2Ob1f1ACio/w==",
+		integrity,
+	)
+
+	_, _, err = integrityHash(bytes.NewBufferString("test"), []SRIAlgorithm{"md5"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,153 @@
+package esox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+)
+
+// Streaming makes Render stream the page instead of rendering it completely
+// before writing anything. The page is buffered until the closing head tag,
+// which is written and flushed right away so the browser can start loading
+// the stylesheets, and the rest of the page is streamed as it is rendered.
+// The flush template func flushes the page rendered so far at any point.
+//
+// Content can be pushed to a stack until the page is complete or flushed
+// with the flush func, so the page is only written up to its first stack
+// placeholder and buffered from there on. A stack in the head, like "head",
+// therefore holds back the rest of the page unless it is flushed, after
+// which pushing to the stack fails the template.
+//
+// A streamed page has no ETag, as it is not known before the page is
+// complete. When the App has CSRF protection, a streamed page is never cached
+// publicly, as its headers are written before a CSRF token may be issued. An
+// error after the head has been written cannot change the status code
+// anymore, so it is logged and marked in the page instead.
+func (t *Template) Streaming() *Template {
+	t.streaming = true
+	return t
+}
+
+// streamWriter buffers the page until its head has been rendered, then it
+// writes the page straight to the response up to the first stack placeholder
+// which has not been flushed.
+type streamWriter struct {
+	w    http.ResponseWriter
+	code int
+
+	// writeHeaders is called right before the status code is written.
+	writeHeaders func()
+	stacks       *templateStacks
+
+	buf     bytes.Buffer
+	started bool
+}
+
+var headEnd = []byte("</head>")
+
+var stackPlaceholderStart = []byte(stackPlaceholderPrefix)
+
+func (s *streamWriter) Write(b []byte) (int, error) {
+	if s.started {
+		if s.buf.Len() > 0 {
+			return s.buf.Write(b)
+		}
+
+		// The stack funcs write their placeholder in a single write.
+		i := bytes.Index(b, stackPlaceholderStart)
+		if i == -1 {
+			return s.w.Write(b)
+		}
+
+		n, err := s.w.Write(b[:i])
+		if err != nil {
+			return n, err
+		}
+
+		s.buf.Write(b[i:])
+		return len(b), nil
+	}
+
+	// Only the new content, and the end of the old in case the tag was
+	// split between the writes, has to be searched.
+	from := max(0, s.buf.Len()-len(headEnd))
+	s.buf.Write(b)
+
+	if bytes.Contains(bytes.ToLower(s.buf.Bytes()[from:]), headEnd) {
+		// The stacks may still be pushed to, so the page is only written up
+		// to the first of them.
+		head := s.buf.Bytes()
+		if i := bytes.Index(head, stackPlaceholderStart); i != -1 {
+			head = head[:i]
+		}
+
+		s.start()
+		_, err := s.w.Write(head)
+		if err != nil {
+			return 0, err
+		}
+		s.buf.Next(len(head))
+
+		err = s.flushResponse()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// start writes the headers and the status code, once.
+func (s *streamWriter) start() {
+	if !s.started {
+		s.started = true
+
+		s.writeHeaders()
+		s.w.WriteHeader(s.code)
+	}
+}
+
+// Flush writes the buffered page with the stacks, and flushes the response.
+func (s *streamWriter) Flush() error {
+	s.start()
+
+	if s.buf.Len() > 0 {
+		_, err := s.w.Write(s.stacks.render(s.buf.Bytes()))
+		if err != nil {
+			return err
+		}
+
+		s.buf.Reset()
+	}
+
+	return s.flushResponse()
+}
+
+func (s *streamWriter) flushResponse() error {
+	err := http.NewResponseController(s.w).Flush()
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+
+	return err
+}
+
+type streamWriterKey struct{}
+
+func getStreamWriter(ctx context.Context) *streamWriter {
+	value, _ := ctx.Value(streamWriterKey{}).(*streamWriter)
+	return value
+}
+
+// streamErrorMarker marks the place in a streamed page where rendering
+// failed. In dev mode it shows the error.
+func streamErrorMarker(ts *Templates, err error) string {
+	if ts.dev {
+		return fmt.Sprintf(`<pre data-esox-error>%s</pre>`, template.HTMLEscapeString(err.Error()))
+	}
+
+	return `<div data-esox-error hidden></div>`
+}
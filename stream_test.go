@@ -0,0 +1,82 @@
+package esox
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xremming/esox/flash"
+)
+
+type testStreamData struct {
+	w       *httptest.ResponseRecorder
+	flushed string
+}
+
+func (*testStreamData) ModTime() time.Time                  { return time.Time{} }
+func (*testStreamData) CacheControl() (bool, time.Duration) { return false, 0 }
+func (*testStreamData) SetFlashes(flashes []flash.Data)     {}
+
+// Load records what had been flushed when the body started rendering.
+func (d *testStreamData) Load() string {
+	if d.w.Flushed {
+		d.flushed = d.w.Body.String()
+	}
+
+	return "loaded"
+}
+
+func (d *testStreamData) Fail() (string, error) {
+	return "", errors.New("failed to load")
+}
+
+func TestTemplateRenderStreaming(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html":  {Data: []byte(`<html><head><link>{{ stack "head" }}</head><body>{{ template "content" . }}{{ stack "scripts" }}</body></html>`)},
+		"index.html": {Data: []byte(`{{ define "content" }}<p>{{ .Load }}</p>{{ push "scripts" "script" }}{{ end }}`)},
+		"late.html":  {Data: []byte(`{{ define "content" }}<p>{{ push "head" "meta" }}</p>{{ end }}`)},
+		"flush.html": {Data: []byte(`{{ define "content" }}{{ flush }}<p>{{ .Load }}{{ push "head" "meta" }}</p>{{ end }}`)},
+		"error.html": {Data: []byte(`{{ define "content" }}<p>{{ .Fail }}</p>{{ end }}`)},
+		"early.html": {Data: []byte(`{{ define "content" }}{{ .Fail }}{{ end }}`)},
+	}, false)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	render := func(name string) (*httptest.ResponseRecorder, *testStreamData) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		data := &testStreamData{w: w}
+
+		(&Template{name: name, baseName: "base.html"}).Streaming().Render(w, r, http.StatusOK, data)
+		return w, data
+	}
+
+	// The page is written up to the head stack, which may still be pushed to.
+	w, data := render("index.html")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "<html><head><link>", data.flushed)
+	assert.Equal(t, "<html><head><link></head><body><p>loaded</p>script</body></html>", w.Body.String())
+	assert.Empty(t, w.Header().Get("ETag"))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+
+	w, _ = render("late.html")
+	assert.Equal(t, "<html><head><link>meta</head><body><p></p></body></html>", w.Body.String())
+
+	w, data = render("flush.html")
+	assert.Equal(t, "<html><head><link></head><body><p>", data.flushed)
+	assert.Equal(t, `<html><head><link></head><body><p>loaded<div data-esox-error hidden></div>`, w.Body.String(), "pushed after the head was flushed")
+
+	w, _ = render("error.html")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `<html><head><link></head><body><p><div data-esox-error hidden></div>`, w.Body.String())
+
+	ts.dev = true
+	w, _ = render("early.html")
+	assert.Equal(t, `<html><head><link></head><body><pre data-esox-error>template: base.html:1:25: executing &#34;content&#34; at &lt;.Fail&gt;: error calling Fail: failed to load</pre>`, w.Body.String())
+}
@@ -0,0 +1,126 @@
+package esox
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"strings"
+	"sync"
+)
+
+const (
+	stackPlaceholderPrefix = "<!--esox:stack "
+	stackPlaceholderSuffix = "-->"
+)
+
+// templateStacks collect the content pushed to the named stacks, like "head"
+// and "scripts", while a page is executed. The stack func only leaves a
+// placeholder, which is replaced with the content once the whole page has
+// been executed. That way a partial in the body can push to a stack rendered
+// earlier in the head. Pushing the same content again is a no-op, so a
+// partial rendered many times adds its assets only once.
+type templateStacks struct {
+	mu      sync.Mutex
+	content map[string][]template.HTML
+
+	// written are the stacks already replaced in the page, which a streamed
+	// page may have sent before it was completely executed.
+	written map[string]bool
+}
+
+// push adds the content to the stack. It fails if the stack has already been
+// written.
+func (s *templateStacks) push(name string, content template.HTML) error {
+	if s == nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.written[name] {
+		return fmt.Errorf("stack %q has already been written", name)
+	}
+
+	if s.content == nil {
+		s.content = make(map[string][]template.HTML)
+	}
+
+	for _, pushed := range s.content[name] {
+		if pushed == content {
+			return nil
+		}
+	}
+
+	s.content[name] = append(s.content[name], content)
+	return nil
+}
+
+// render replaces the placeholders in the page with the content pushed to the
+// stacks.
+func (s *templateStacks) render(page []byte) []byte {
+	if s == nil || !bytes.Contains(page, []byte(stackPlaceholderPrefix)) {
+		return page
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out bytes.Buffer
+	for {
+		start := bytes.Index(page, []byte(stackPlaceholderPrefix))
+		if start == -1 {
+			out.Write(page)
+			return out.Bytes()
+		}
+
+		end := bytes.Index(page[start:], []byte(stackPlaceholderSuffix))
+		if end == -1 {
+			out.Write(page)
+			return out.Bytes()
+		}
+		end += start
+
+		name := string(page[start+len(stackPlaceholderPrefix) : end])
+		if s.written == nil {
+			s.written = make(map[string]bool)
+		}
+		s.written[name] = true
+
+		out.Write(page[:start])
+		for _, content := range s.content[name] {
+			out.WriteString(string(content))
+		}
+
+		page = page[end+len(stackPlaceholderSuffix):]
+	}
+}
+
+func stackPlaceholder(name string) (template.HTML, error) {
+	if name == "" || strings.ContainsAny(name, "<>") {
+		return "", fmt.Errorf("invalid stack name: %q", name)
+	}
+
+	return template.HTML(stackPlaceholderPrefix + name + stackPlaceholderSuffix), nil
+}
+
+// stackContent returns the content as HTML, escaping it unless it already is
+// HTML, like the output of the stylesheet and partial funcs.
+func stackContent(content any) template.HTML {
+	switch content := content.(type) {
+	case template.HTML:
+		return content
+	case string:
+		return template.HTML(template.HTMLEscapeString(content))
+	default:
+		return template.HTML(template.HTMLEscapeString(fmt.Sprint(content)))
+	}
+}
+
+type templateStacksKey struct{}
+
+func getTemplateStacks(ctx context.Context) *templateStacks {
+	value, _ := ctx.Value(templateStacksKey{}).(*templateStacks)
+	return value
+}
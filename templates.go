@@ -0,0 +1,269 @@
+package esox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const TemplatesPrefix = "templates"
+
+// TemplateExtensions are the extensions of the files LoadTemplates parses,
+// other files in the templates directory are skipped.
+var TemplateExtensions = []string{".html", ".htm", ".tmpl", ".gohtml"}
+
+type templateFile struct {
+	text    string
+	tmpl    *template.Template
+	modTime time.Time
+	size    int64
+}
+
+type templatePage struct {
+	tmpl *template.Template
+
+	// files are the parsed files the page was built from.
+	files []*template.Template
+}
+
+// Templates is the set of template files of an app. Outside of dev mode each
+// file is read and parsed only once, and each page, a child template parsed
+// on top of its layouts and base template, is built only once by cloning the
+// parsed base template. Rendering then only clones the page to bind the
+// template funcs to the request. In dev mode the files, and the pages built
+// from them, are reloaded when the modification time or size of a file
+// changes.
+//
+// Partials are parsed once as well, and executed by cloning them the same way.
+type Templates struct {
+	fsys fs.FS
+	dev  bool
+
+	// reloadMu is held while a file is reloaded in dev mode, so concurrent
+	// requests wait for the reload instead of repeating it.
+	reloadMu sync.Mutex
+
+	mu    sync.RWMutex
+	files map[string]templateFile
+	pages map[string]templatePage
+}
+
+func newTemplates(fsys fs.FS, dev bool) *Templates {
+	return &Templates{
+		fsys:  fsys,
+		dev:   dev,
+		files: make(map[string]templateFile),
+		pages: make(map[string]templatePage),
+	}
+}
+
+// LoadTemplates parses every file in fsys with one of the TemplateExtensions
+// and builds the pages. All of the problems found are returned at once.
+func LoadTemplates(fsys fs.FS, dev bool, pages ...*Template) (*Templates, error) {
+	ts := newTemplates(fsys, dev)
+
+	var errs []error
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if name == "." && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+
+			return err
+		}
+
+		if d.IsDir() || !isTemplateFile(name) {
+			return nil
+		}
+
+		if _, err := ts.file(name); err != nil {
+			errs = append(errs, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range pages {
+		if _, err := ts.page(t); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return ts, errors.Join(errs...)
+}
+
+func isTemplateFile(name string) bool {
+	return slices.Contains(TemplateExtensions, path.Ext(name))
+}
+
+// Names returns the names of all of the template files loaded so far, sorted.
+func (ts *Templates) Names() []string {
+	ts.mu.RLock()
+	out := make([]string, 0, len(ts.files))
+	for name := range ts.files {
+		out = append(out, name)
+	}
+	ts.mu.RUnlock()
+
+	sort.Strings(out)
+	return out
+}
+
+// parseTemplate parses the template with the template funcs bound to the
+// background context. The funcs are only placeholders, they are rebound to
+// the request with Funcs on a clone before the template is executed.
+func parseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).
+		Funcs(templateFuncs(context.Background(), nil)).
+		Parse(text)
+}
+
+func (ts *Templates) cachedFile(name string) (templateFile, bool) {
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+
+	file, ok := ts.files[name]
+	return file, ok
+}
+
+func (ts *Templates) file(name string) (templateFile, error) {
+	file, ok := ts.cachedFile(name)
+	if ok && !ts.dev {
+		return file, nil
+	}
+
+	if ts.dev {
+		ts.reloadMu.Lock()
+		defer ts.reloadMu.Unlock()
+
+		// The file may have been reloaded while waiting for the lock.
+		file, ok = ts.cachedFile(name)
+
+		info, err := fs.Stat(ts.fsys, name)
+		if ok && errors.Is(err, fs.ErrNotExist) {
+			// Editors may save by replacing the file, so it can be missing
+			// for a moment. The last version is used until it is back.
+			return file, nil
+		} else if err != nil {
+			return templateFile{}, err
+		}
+
+		if ok && file.modTime.Equal(info.ModTime()) && file.size == info.Size() {
+			return file, nil
+		}
+	}
+
+	return ts.load(name)
+}
+
+func (ts *Templates) load(name string) (templateFile, error) {
+	info, err := fs.Stat(ts.fsys, name)
+	if err != nil {
+		return templateFile{}, err
+	}
+
+	content, err := fs.ReadFile(ts.fsys, name)
+	if err != nil {
+		return templateFile{}, err
+	}
+
+	// A file which fails to parse is not stored, so in dev mode it is parsed
+	// again on the next request.
+	tmpl, err := parseTemplate(name, string(content))
+	if err != nil {
+		return templateFile{}, fmt.Errorf("failed to parse template: %w", err)
+	}
+
+	file := templateFile{
+		text:    string(content),
+		tmpl:    tmpl,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+
+	ts.mu.Lock()
+	ts.files[name] = file
+	ts.mu.Unlock()
+
+	return file, nil
+}
+
+// page returns the child template parsed on top of its layouts and a clone of
+// its base template. The page must be cloned before it is executed.
+func (ts *Templates) page(t *Template) (*template.Template, error) {
+	chain := t.chain()
+	key := strings.Join(chain, "\x00")
+
+	ts.mu.RLock()
+	cached, ok := ts.pages[key]
+	ts.mu.RUnlock()
+
+	if ok && !ts.dev {
+		return cached.tmpl, nil
+	}
+
+	files := make([]templateFile, len(chain))
+	fresh := ok
+	for i, name := range chain {
+		file, err := ts.file(name)
+		if err != nil {
+			return nil, err
+		}
+
+		files[i] = file
+		fresh = fresh && cached.files[i] == file.tmpl
+	}
+
+	if fresh {
+		return cached.tmpl, nil
+	}
+
+	page, err := files[0].tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	parsed := []*template.Template{files[0].tmpl}
+	for i, file := range files[1:] {
+		// Parsing redefines the templates and blocks the file defines, so
+		// every file overrides the ones it is parsed on top of.
+		page, err = page.Parse(file.text)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse template %s on top of %s: %w", chain[i+1], chain[i], err)
+		}
+
+		parsed = append(parsed, file.tmpl)
+	}
+
+	ts.mu.Lock()
+	ts.pages[key] = templatePage{tmpl: page, files: parsed}
+	ts.mu.Unlock()
+
+	return page, nil
+}
+
+// bind returns a clone of the template with the template funcs bound to ctx.
+func (ts *Templates) bind(ctx context.Context, tmpl *template.Template) (*template.Template, error) {
+	clone, err := tmpl.Clone()
+	if err != nil {
+		return nil, err
+	}
+
+	return clone.Funcs(templateFuncs(ctx, ts)), nil
+}
+
+// defaultTemplates is used when the context has not been set up by an App,
+// it reads the files from TemplatesPrefix.
+var defaultTemplates = newTemplates(os.DirFS(TemplatesPrefix), false)
@@ -0,0 +1,205 @@
+package esox
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xremming/esox/flash"
+)
+
+type testRenderData struct {
+	Title string
+}
+
+func (testRenderData) ModTime() time.Time                  { return time.Time{} }
+func (testRenderData) CacheControl() (bool, time.Duration) { return false, 0 }
+func (*testRenderData) SetFlashes(flashes []flash.Data)    {}
+
+var testTemplatesFS = fstest.MapFS{
+	"base.html":    {Data: []byte(`<title>{{ block "title" . }}Default{{ end }}</title>{{ template "content" . }}`)},
+	"index.html":   {Data: []byte(`{{ define "title" }}{{ .Title }}{{ end }}{{ define "content" }}{{ partial "_nav.html" . }}{{ end }}`)},
+	"_nav.html":    {Data: []byte(`<nav>{{ .Title }}</nav>`)},
+	"missing.html": {Data: []byte(`{{ define "content" }}{{ end }}`)},
+}
+
+func TestLoadTemplates(t *testing.T) {
+	ts, err := LoadTemplates(testTemplatesFS, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"_nav.html", "base.html", "index.html", "missing.html"}, ts.Names())
+
+	_, err = LoadTemplates(fstest.MapFS{
+		"a.html": {Data: []byte(`{{ if }}`)},
+		"b.html": {Data: []byte(`{{ end }}`)},
+	}, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a.html")
+	assert.Contains(t, err.Error(), "b.html")
+
+	ts, err = LoadTemplates(fstest.MapFS{
+		"base.html": {Data: []byte(`{{ template "content" . }}`)},
+		"logo.svg":  {Data: []byte(`<svg>{{</svg>`)},
+		"README":    {Data: []byte(`{{ end }}`)},
+	}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"base.html"}, ts.Names())
+
+	_, err = LoadTemplates(testTemplatesFS, false, GetTemplate("nope.html", "base.html"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "nope.html")
+}
+
+func TestTemplateRender(t *testing.T) {
+	ts, err := LoadTemplates(testTemplatesFS, false)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	tmpl := &Template{name: "index.html", baseName: "base.html"}
+
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		tmpl.Render(w, r, http.StatusOK, &testRenderData{Title: "Home"})
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, `<title>Home</title><nav>Home</nav>`, w.Body.String())
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	(&Template{name: "missing.html", baseName: "base.html"}).Render(w, r, http.StatusNotFound, &testRenderData{})
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, `<title>Default</title>`, w.Body.String())
+}
+
+func TestTemplatesDevReload(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.html":  {Data: []byte(`{{ template "content" . }}`)},
+		"index.html": {Data: []byte(`{{ define "content" }}v1{{ end }}`), ModTime: time.Unix(1, 0)},
+	}
+
+	ts, err := LoadTemplates(fsys, true)
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	tmpl := &Template{name: "index.html", baseName: "base.html"}
+	render := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+		tmpl.Render(w, r, http.StatusNotFound, &testRenderData{})
+		return w
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, "v1", render().Body.String())
+		}()
+	}
+	wg.Wait()
+
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`{{ define "content" }}v2{{ end }}`), ModTime: time.Unix(2, 0)}
+	assert.Equal(t, "v2", render().Body.String())
+
+	delete(fsys, "index.html")
+	assert.Equal(t, "v2", render().Body.String(), "the last version is used while the file is missing")
+
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`{{ define "content" }}{{ if }}{{ end }}`), ModTime: time.Unix(3, 0)}
+	w := render()
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "index.html")
+
+	fsys["index.html"] = &fstest.MapFile{Data: []byte(`{{ define "content" }}v4{{ end }}`), ModTime: time.Unix(4, 0)}
+	assert.Equal(t, "v4", render().Body.String())
+}
+
+func TestTemplateLayoutsAndStacks(t *testing.T) {
+	ts, err := LoadTemplates(fstest.MapFS{
+		"base.html": {Data: []byte(
+			`<head>{{ stack "head" }}</head>` +
+				`<h1>{{ block "title" . }}Site{{ end }}</h1>` +
+				`{{ block "nav" . }}<nav>site</nav>{{ end }}` +
+				`{{ template "content" . }}{{ stack "scripts" }}`,
+		)},
+		"dashboard.html": {Data: []byte(
+			`{{ define "nav" }}<nav>dashboard</nav>{{ end }}` +
+				`{{ define "title" }}Dashboard{{ end }}`,
+		)},
+		"users.html": {Data: []byte(
+			`{{ define "title" }}Users{{ end }}` +
+				`{{ define "content" }}{{ range . }}{{ partial "_user.html" . }}{{ end }}{{ push "scripts" "<x>" }}{{ end }}`,
+		)},
+		"_user.html": {Data: []byte(`{{ push "head" (print "<meta name=user>") }}{{ push "head" (stylesheet "user.css") }}<p>{{ . }}</p>`)},
+	}, false)
+	require.NoError(t, err)
+
+	manifest := NewDevStaticManifest(fstest.MapFS{
+		"user.css": {Data: []byte("p {}")},
+	})
+
+	user, err := manifest.Get("user.css")
+	require.NoError(t, err)
+
+	ctx := context.WithValue(context.Background(), templatesKey{}, ts)
+	ctx = context.WithValue(ctx, staticManifestKey{}, manifest)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	tmpl := &Template{name: "users.html", baseName: "base.html", layouts: []string{"dashboard.html"}}
+	tmpl.Render(w, r, http.StatusNotFound, &testUsersData{"a", "b"})
+
+	assert.Equal(t,
+		`<head>&lt;meta name=user&gt;<link rel="stylesheet" href="/static/`+user.PathWithHash+`" integrity="`+user.Integrity+`"></head>`+
+			`<h1>Users</h1><nav>dashboard</nav><p>a</p><p>b</p>&lt;x&gt;`,
+		w.Body.String(),
+	)
+}
+
+type testUsersData []string
+
+func (testUsersData) ModTime() time.Time                  { return time.Time{} }
+func (testUsersData) CacheControl() (bool, time.Duration) { return false, 0 }
+func (*testUsersData) SetFlashes(flashes []flash.Data)    {}
+
+func TestTemplateExecute(t *testing.T) {
+	assert.Equal(t, time.UTC, GetLocation(context.Background()))
+	assert.Nil(t, GetNameMapping(context.Background()))
+
+	app := App{
+		TemplateResources: fstest.MapFS{
+			"email.html":   {Data: []byte(`<head>{{ stack "head" }}</head>{{ template "content" . }}`)},
+			"welcome.html": {Data: []byte(`{{ define "content" }}{{ push "head" "Hi" }}<a href="{{ urlFor "home" }}">{{ .Title }}</a>{{ end }}`)},
+		},
+		StaticResources: fstest.MapFS{},
+		URLs:            URLs{{Name: "home", Path: "/home"}},
+	}
+
+	ctx, err := app.Context(context.Background(), RunConfig{})
+	require.NoError(t, err)
+
+	var b strings.Builder
+	tmpl := &Template{name: "welcome.html", baseName: "email.html"}
+	require.NoError(t, tmpl.Execute(ctx, &b, testRenderData{Title: "Welcome"}))
+	assert.Equal(t, `<head>Hi</head><a href="/home">Welcome</a>`, b.String())
+
+	// In dev mode the changed templates are reloaded.
+	ctx, err = app.Context(context.Background(), RunConfig{Dev: true})
+	require.NoError(t, err)
+
+	app.TemplateResources.(fstest.MapFS)["welcome.html"] = &fstest.MapFile{
+		Data: []byte(`{{ define "content" }}{{ .Title }}!{{ end }}`),
+	}
+
+	b.Reset()
+	require.NoError(t, tmpl.Execute(ctx, &b, testRenderData{Title: "Welcome"}))
+	assert.Equal(t, `<head></head>Welcome!`, b.String())
+}
@@ -0,0 +1,65 @@
+package esox
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reflect"
+)
+
+// TypedTemplate is a Template which only renders data of the type T, so a
+// handler cannot pass the data of another page to it. App.Validate also
+// checks that the fields and methods the page refers to exist on T.
+type TypedTemplate[T RenderData] struct {
+	t *Template
+}
+
+// GetTypedTemplate returns the page template rendering data of the type T,
+// see GetTemplate.
+func GetTypedTemplate[T RenderData](name, baseName string, layouts ...string) *TypedTemplate[T] {
+	return &TypedTemplate[T]{t: &Template{
+		name:     name,
+		baseName: baseName,
+		layouts:  layouts,
+		dataType: reflect.TypeFor[T](),
+	}}
+}
+
+// Template returns the untyped template, for example to list it in
+// App.Templates.
+func (t *TypedTemplate[T]) Template() *Template {
+	return t.t
+}
+
+// HTMXBlock is Template.HTMXBlock.
+func (t *TypedTemplate[T]) HTMXBlock(name string) *TypedTemplate[T] {
+	t.t.HTMXBlock(name)
+	return t
+}
+
+// HTMXTargets is Template.HTMXTargets.
+func (t *TypedTemplate[T]) HTMXTargets(names ...string) *TypedTemplate[T] {
+	t.t.HTMXTargets(names...)
+	return t
+}
+
+// Streaming is Template.Streaming.
+func (t *TypedTemplate[T]) Streaming() *TypedTemplate[T] {
+	t.t.Streaming()
+	return t
+}
+
+// Render is Template.Render.
+func (t *TypedTemplate[T]) Render(w http.ResponseWriter, r *http.Request, code int, data T) {
+	t.t.Render(w, r, code, data)
+}
+
+// RenderBlock is Template.RenderBlock.
+func (t *TypedTemplate[T]) RenderBlock(w http.ResponseWriter, r *http.Request, code int, blockName string, data T) {
+	t.t.RenderBlock(w, r, code, blockName, data)
+}
+
+// Execute is Template.Execute.
+func (t *TypedTemplate[T]) Execute(ctx context.Context, w io.Writer, data T) error {
+	return t.t.Execute(ctx, w, data)
+}
@@ -6,6 +6,13 @@ type URL struct {
 	Name    string
 	Handler http.Handler
 	Path    string
+
+	// JSON also serves the handler from the path with a .json suffix, for
+	// example /users.json for /users, where the pages it renders are rendered
+	// as JSON. They are rendered as JSON from the path itself as well when the
+	// Accept header prefers it. Pages of URLs without JSON are only rendered
+	// as HTML. App.Handler fails when the path ends with a wildcard.
+	JSON bool
 }
 
 type URLs []URL
@@ -33,6 +40,7 @@ func (urls URLs) WithPrefix(prefix string) URLs {
 			Name:    url.Name,
 			Handler: url.Handler,
 			Path:    prefix + url.Path,
+			JSON:    url.JSON,
 		})
 	}
 
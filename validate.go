@@ -0,0 +1,189 @@
+package esox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"text/template/parse"
+
+	"github.com/rs/zerolog"
+)
+
+// validatedFuncs are the template funcs whose first argument, when it is a
+// constant string, is checked by Validate.
+var validatedFuncs = map[string]bool{
+	"urlFor":       true,
+	"urlForStatic": true,
+	"stylesheet":   true,
+	"javascript":   true,
+	"module":       true,
+	"preload":      true,
+	"image":        true,
+	"partial":      true,
+}
+
+// templateRef is a call of one of the validatedFuncs with a constant string
+// as its first argument.
+type templateRef struct {
+	location string
+	fn       string
+	arg      string
+}
+
+// Validate checks the route names, static files and partials referred to
+// with constant strings from the templates, and the fields the TypedTemplates
+// in App.Templates refer to, so that a typo is found before anyone opens the
+// page. The templates of ctx are used when it has been set up by the App,
+// otherwise they are parsed first. It returns all of the problems found at
+// once. Run calls Validate on startup outside of dev mode.
+func (a *App) Validate(ctx context.Context) error {
+	log := zerolog.Ctx(ctx)
+
+	var errs []error
+
+	templates, ok := ctx.Value(templatesKey{}).(*Templates)
+	if !ok {
+		var err error
+		templates, err = LoadTemplates(a.templateFS(), false, a.Templates...)
+		if err != nil {
+			errs = append(errs, err)
+		}
+
+		if templates == nil {
+			return errors.Join(errs...)
+		}
+	}
+
+	if a.FlashPartial != "" {
+		if _, err := templates.file(a.FlashPartial); err != nil {
+			errs = append(errs, fmt.Errorf("flash partial: %w", err))
+		}
+	}
+
+	nameMapping, ok := ctx.Value(nameMappingKey{}).(map[string]URL)
+	if !ok {
+		nameMapping = a.nameMapping(*log)
+	}
+
+	manifest, ok := ctx.Value(staticManifestKey{}).(*StaticManifest)
+	if !ok {
+		var err error
+		manifest, err = a.staticManifest(*log, false)
+		if err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+
+	for _, name := range templates.Names() {
+		file, err := templates.file(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, tmpl := range file.tmpl.Templates() {
+			if tmpl.Tree == nil {
+				continue
+			}
+
+			for _, ref := range templateRefs(tmpl.Tree, tmpl.Tree.Root) {
+				if err := validateRef(ref, templates, nameMapping, manifest); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %s %q: %w", ref.location, ref.fn, ref.arg, err))
+				}
+			}
+		}
+	}
+
+	for _, t := range a.Templates {
+		if t.dataType != nil {
+			errs = append(errs, validateTypes(templates, t)...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateRef(ref templateRef, templates *Templates, nameMapping map[string]URL, manifest *StaticManifest) error {
+	switch ref.fn {
+	case "urlFor":
+		if _, ok := nameMapping[ref.arg]; !ok {
+			return errors.New("unknown route name")
+		}
+
+		return nil
+	case "partial":
+		_, err := templates.file(ref.arg)
+		return err
+	default:
+		_, err := manifest.Get(ref.arg)
+		return err
+	}
+}
+
+// templateRefs walks the parse tree for calls of the validatedFuncs.
+func templateRefs(tree *parse.Tree, node parse.Node) []templateRef {
+	var out []templateRef
+
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+
+		for _, n := range node.Nodes {
+			out = append(out, templateRefs(tree, n)...)
+		}
+	case *parse.ActionNode:
+		out = append(out, templateRefs(tree, node.Pipe)...)
+	case *parse.IfNode:
+		out = append(out, branchRefs(tree, &node.BranchNode)...)
+	case *parse.RangeNode:
+		out = append(out, branchRefs(tree, &node.BranchNode)...)
+	case *parse.WithNode:
+		out = append(out, branchRefs(tree, &node.BranchNode)...)
+	case *parse.TemplateNode:
+		out = append(out, templateRefs(tree, node.Pipe)...)
+	case *parse.PipeNode:
+		if node == nil {
+			return nil
+		}
+
+		for i, cmd := range node.Cmds {
+			out = append(out, templateRefs(tree, cmd)...)
+
+			// A constant piped to a func without other arguments, as in
+			// {{ "home" | urlFor }}, is its first argument as well.
+			if i == 0 || len(cmd.Args) != 1 || len(node.Cmds[i-1].Args) != 1 {
+				continue
+			}
+
+			ident, isIdent := cmd.Args[0].(*parse.IdentifierNode)
+			str, isString := node.Cmds[i-1].Args[0].(*parse.StringNode)
+			if isIdent && isString && validatedFuncs[ident.Ident] {
+				location, _ := tree.ErrorContext(cmd)
+				out = append(out, templateRef{location: location, fn: ident.Ident, arg: str.Text})
+			}
+		}
+	case *parse.CommandNode:
+		if len(node.Args) >= 2 {
+			ident, isIdent := node.Args[0].(*parse.IdentifierNode)
+			str, isString := node.Args[1].(*parse.StringNode)
+			if isIdent && isString && validatedFuncs[ident.Ident] {
+				location, _ := tree.ErrorContext(node)
+				out = append(out, templateRef{location: location, fn: ident.Ident, arg: str.Text})
+			}
+		}
+
+		for _, arg := range node.Args {
+			out = append(out, templateRefs(tree, arg)...)
+		}
+	}
+
+	return out
+}
+
+func branchRefs(tree *parse.Tree, node *parse.BranchNode) []templateRef {
+	out := templateRefs(tree, node.Pipe)
+	out = append(out, templateRefs(tree, node.List)...)
+	return append(out, templateRefs(tree, node.ElseList)...)
+}
@@ -0,0 +1,52 @@
+package esox
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppValidate(t *testing.T) {
+	app := App{
+		TemplateResources: fstest.MapFS{
+			"base.html": {Data: []byte(`{{ stylesheet "site.css" }}{{ javascript "app.cs" }}{{ template "content" . }}`)},
+			"index.html": {Data: []byte(`{{ define "content" }}` +
+				`{{ if .Admin }}<a href="{{ urlFor "hom" }}">{{ else }}<a href="{{ urlFor "home" }}">{{ end }}` +
+				`{{ partial "_nav.html" . }}{{ partial (print "_" "x") . }}{{ urlForStatic .Image }}{{ "hme" | urlFor }}` +
+				`{{ end }}`)},
+			"_nav.html": {Data: []byte(`{{ range .Links }}{{ partial "_link.html" . }}{{ end }}`)},
+		},
+		StaticResources: fstest.MapFS{
+			"site.css": {Data: []byte("body {}")},
+		},
+		URLs: URLs{{Name: "home", Path: "/"}},
+	}
+
+	err := app.Validate(context.Background())
+	require.Error(t, err)
+
+	assert.Equal(t,
+		`_nav.html:1:21: partial "_link.html": open _link.html: file does not exist`+"\n"+
+			`base.html:1:30: javascript "app.cs": open app.cs: file does not exist`+"\n"+
+			`index.html:1:49: urlFor "hom": unknown route name`+"\n"+
+			`index.html:1:209: urlFor "hme": unknown route name`,
+		err.Error(),
+	)
+
+	app.TemplateResources = fstest.MapFS{
+		"index.html": {Data: []byte(`{{ stylesheet "site.css" }}{{ urlFor "home" }}`)},
+	}
+	assert.NoError(t, app.Validate(context.Background()))
+
+	// The templates already loaded into the context are not parsed again.
+	ctx, err := app.Context(context.Background(), RunConfig{})
+	require.NoError(t, err)
+
+	app.TemplateResources = fstest.MapFS{
+		"index.html": {Data: []byte(`{{ if }}`)},
+	}
+	assert.NoError(t, app.Validate(ctx))
+}
@@ -0,0 +1,323 @@
+package esox
+
+import (
+	"fmt"
+	"html/template"
+	"reflect"
+	"text/template/parse"
+)
+
+// typeChecker checks the field references of a page against the type of its
+// data. Where the type of dot cannot be known, for example in the result of a
+// func or an interface value, nothing is checked.
+type typeChecker struct {
+	templates *Templates
+	// page is the page being checked, and files are the files it is parsed
+	// from, in the order they are parsed in.
+	page    *template.Template
+	files   []*template.Template
+	visited map[typeCheckKey]bool
+	errs    []error
+}
+
+type typeCheckKey struct {
+	tmpl *template.Template
+	name string
+	dot  reflect.Type
+}
+
+// typeCheckScope is the type of dot and the types of the variables known at
+// a point in a template.
+type typeCheckScope struct {
+	dot  reflect.Type
+	vars map[string]reflect.Type
+}
+
+func (s typeCheckScope) with(dot reflect.Type) typeCheckScope {
+	return typeCheckScope{dot: dot, vars: s.vars}
+}
+
+// declare returns a scope with the variables declared, the variables of the
+// enclosing scope are not changed.
+func (s typeCheckScope) declare(decl []*parse.VariableNode, types ...reflect.Type) typeCheckScope {
+	vars := make(map[string]reflect.Type, len(s.vars)+len(decl))
+	for name, t := range s.vars {
+		vars[name] = t
+	}
+
+	for i, v := range decl {
+		var t reflect.Type
+		if i < len(types) {
+			t = types[i]
+		}
+
+		vars[v.Ident[0]] = t
+	}
+
+	return typeCheckScope{dot: s.dot, vars: vars}
+}
+
+// validateTypes checks the page of the template against its data type,
+// starting from the base template and the htmx blocks of the page.
+func validateTypes(templates *Templates, t *Template) []error {
+	page, err := templates.page(t)
+	if err != nil {
+		return []error{err}
+	}
+
+	c := &typeChecker{templates: templates, page: page, visited: make(map[typeCheckKey]bool)}
+	for _, name := range t.chain() {
+		file, err := templates.file(name)
+		if err != nil {
+			return []error{err}
+		}
+
+		c.files = append(c.files, file.tmpl)
+	}
+
+	c.template(page, page.Name(), t.dataType)
+
+	if t.htmxBlock != "" {
+		c.template(page, t.htmxBlock, t.dataType)
+	}
+
+	for _, name := range t.htmxTargets {
+		c.template(page, name, t.dataType)
+	}
+
+	return c.errs
+}
+
+// template checks the named template of tmpl with dot of the type.
+func (c *typeChecker) template(tmpl *template.Template, name string, dot reflect.Type) {
+	if dot == nil {
+		return
+	}
+
+	key := typeCheckKey{tmpl: tmpl, name: name, dot: dot}
+	if c.visited[key] {
+		return
+	}
+	c.visited[key] = true
+
+	tree := c.tree(tmpl, name)
+	if tree == nil {
+		return
+	}
+
+	scope := typeCheckScope{dot: dot, vars: map[string]reflect.Type{"$": dot}}
+	c.walk(tmpl, tree, tree.Root, scope)
+}
+
+// tree returns the parse tree of the named template. The trees of the page
+// are looked up from the file defining them last, so that the errors have
+// the location in that file instead of the base template.
+func (c *typeChecker) tree(tmpl *template.Template, name string) *parse.Tree {
+	if tmpl == c.page {
+		for i := len(c.files) - 1; i >= 0; i-- {
+			named := c.files[i].Lookup(name)
+			if named != nil && named.Tree != nil && !parse.IsEmptyTree(named.Tree.Root) {
+				return named.Tree
+			}
+		}
+	}
+
+	named := tmpl.Lookup(name)
+	if named == nil {
+		return nil
+	}
+
+	return named.Tree
+}
+
+func (c *typeChecker) walk(tmpl *template.Template, tree *parse.Tree, node parse.Node, scope typeCheckScope) {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return
+		}
+
+		// The variables declared in the list are not visible after it.
+		scope = scope.declare(nil)
+		for _, n := range node.Nodes {
+			c.walk(tmpl, tree, n, scope)
+		}
+	case *parse.ActionNode:
+		t := c.pipe(tmpl, tree, node.Pipe, scope)
+		if !node.Pipe.IsAssign {
+			for _, v := range node.Pipe.Decl {
+				scope.vars[v.Ident[0]] = t
+			}
+		}
+	case *parse.IfNode:
+		c.pipe(tmpl, tree, node.Pipe, scope)
+		c.walk(tmpl, tree, node.List, scope)
+		c.walk(tmpl, tree, node.ElseList, scope)
+	case *parse.WithNode:
+		t := c.pipe(tmpl, tree, node.Pipe, scope)
+		c.walk(tmpl, tree, node.List, scope.declare(node.Pipe.Decl, t).with(t))
+		c.walk(tmpl, tree, node.ElseList, scope)
+	case *parse.RangeNode:
+		key, elem := rangeTypes(c.pipe(tmpl, tree, node.Pipe, scope))
+		inner := scope.with(elem)
+		if len(node.Pipe.Decl) == 1 {
+			inner = inner.declare(node.Pipe.Decl, elem)
+		} else {
+			inner = inner.declare(node.Pipe.Decl, key, elem)
+		}
+
+		c.walk(tmpl, tree, node.List, inner)
+		c.walk(tmpl, tree, node.ElseList, scope)
+	case *parse.TemplateNode:
+		var dot reflect.Type
+		if node.Pipe != nil {
+			dot = c.pipe(tmpl, tree, node.Pipe, scope)
+		}
+
+		c.template(tmpl, node.Name, dot)
+	}
+}
+
+// pipe checks the pipeline and returns the type of its value, nil if it
+// cannot be known.
+func (c *typeChecker) pipe(tmpl *template.Template, tree *parse.Tree, pipe *parse.PipeNode, scope typeCheckScope) reflect.Type {
+	if pipe == nil {
+		return nil
+	}
+
+	var t reflect.Type
+	for _, cmd := range pipe.Cmds {
+		t = c.command(tmpl, tree, cmd, scope)
+	}
+
+	return t
+}
+
+func (c *typeChecker) command(tmpl *template.Template, tree *parse.Tree, cmd *parse.CommandNode, scope typeCheckScope) reflect.Type {
+	types := make([]reflect.Type, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		types[i] = c.arg(tmpl, tree, arg, scope)
+	}
+
+	if len(cmd.Args) == 0 {
+		return nil
+	}
+
+	if ident, ok := cmd.Args[0].(*parse.IdentifierNode); ok {
+		// A partial is rendered with its second argument as dot.
+		if ident.Ident == "partial" && len(cmd.Args) == 3 {
+			if name, ok := cmd.Args[1].(*parse.StringNode); ok {
+				if file, err := c.templates.file(name.Text); err == nil {
+					c.template(file.tmpl, file.tmpl.Name(), types[2])
+				}
+			}
+		}
+
+		return nil
+	}
+
+	return types[0]
+}
+
+func (c *typeChecker) arg(tmpl *template.Template, tree *parse.Tree, node parse.Node, scope typeCheckScope) reflect.Type {
+	switch node := node.(type) {
+	case *parse.DotNode:
+		return scope.dot
+	case *parse.FieldNode:
+		return c.fields(tree, node, scope.dot, node.Ident)
+	case *parse.VariableNode:
+		return c.fields(tree, node, scope.vars[node.Ident[0]], node.Ident[1:])
+	case *parse.ChainNode:
+		if pipe, ok := node.Node.(*parse.PipeNode); ok {
+			return c.fields(tree, node, c.pipe(tmpl, tree, pipe, scope), node.Field)
+		}
+	case *parse.PipeNode:
+		return c.pipe(tmpl, tree, node, scope)
+	}
+
+	return nil
+}
+
+// fields resolves the chain of fields on the type, recording an error for a
+// field which does not exist.
+func (c *typeChecker) fields(tree *parse.Tree, node parse.Node, t reflect.Type, idents []string) reflect.Type {
+	for _, ident := range idents {
+		var err error
+		t, err = fieldType(t, ident)
+		if err != nil {
+			location, _ := tree.ErrorContext(node)
+			c.errs = append(c.errs, fmt.Errorf("%s: %s: %w", location, node, err))
+			return nil
+		}
+	}
+
+	return t
+}
+
+// fieldType returns the type of the field or method of the type, like it is
+// evaluated by text/template, nil if it cannot be known.
+func fieldType(t reflect.Type, name string) (reflect.Type, error) {
+	if t == nil {
+		return nil, nil
+	}
+
+	methods := t
+	if t.Kind() != reflect.Pointer && t.Kind() != reflect.Interface {
+		methods = reflect.PointerTo(t)
+	}
+
+	if method, ok := methods.MethodByName(name); ok {
+		if method.Type.NumOut() == 0 {
+			return nil, nil
+		}
+
+		return method.Type.Out(0), nil
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil, nil
+	case reflect.Map:
+		return t.Elem(), nil
+	case reflect.Struct:
+		field, ok := t.FieldByName(name)
+		if !ok {
+			return nil, fmt.Errorf("can't evaluate field %s in type %s", name, t)
+		}
+
+		if !field.IsExported() {
+			return nil, fmt.Errorf("%s is an unexported field of struct type %s", name, t)
+		}
+
+		return field.Type, nil
+	}
+
+	return nil, fmt.Errorf("can't evaluate field %s in type %s", name, t)
+}
+
+// rangeTypes returns the types of the keys and the elements range iterates
+// over, nil when they cannot be known.
+func rangeTypes(t reflect.Type) (key, elem reflect.Type) {
+	if t == nil {
+		return nil, nil
+	}
+
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return reflect.TypeFor[int](), t.Elem()
+	case reflect.Map:
+		return t.Key(), t.Elem()
+	case reflect.Chan:
+		return nil, t.Elem()
+	}
+
+	return nil, nil
+}